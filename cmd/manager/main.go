@@ -2,7 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"expvar"
+	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/client-go/rest"
@@ -28,6 +35,65 @@ const (
 	routerServerNameEnv        = "ROUTER_SERVERNAME"
 	routerTransportEnv         = "ROUTER_TRANSPORT"
 	controllerSchemeEnv        = "CONTROLLER_SCHEME"
+	routerDiscoveryEnv         = "ROUTER_DISCOVERY"
+	routerTLSSecretEnv         = "ROUTER_TLS_SECRET"
+	networkPolicyEnv           = "PROXY_NETWORK_POLICY"
+	networkPolicyCIDRsEnv      = "PROXY_NETWORK_POLICY_CIDRS"
+	networkPolicyNamespaceEnv  = "PROXY_NETWORK_POLICY_NAMESPACE_SELECTOR"
+	portSourceEnv              = "PORT_SOURCE"
+	portSourceFileEnv          = "PORT_SOURCE_FILE"
+	conflictPolicyEnv          = "PORT_CONFLICT_POLICY"
+	metricsAddressEnv          = "METRICS_ADDRESS"
+	portPolicyEnv              = "PORT_POLICY"
+	httpPortPolicyEnv          = "HTTP_PORT_POLICY"
+	tcpPortPolicyEnv           = "TCP_PORT_POLICY"
+	deletionGuardPercentEnv    = "DELETION_GUARD_PERCENT"
+	deletionGuardObservEnv     = "DELETION_GUARD_OBSERVATIONS"
+	persistStateEnv            = "PERSIST_STATE"
+	stateStaleAfterEnv         = "STATE_STALE_AFTER"
+	proxyAddressEnv            = "PROXY_ADDRESS"
+	proxyExternalIPsEnv        = "PROXY_EXTERNAL_IPS"
+	proxyLoadBalancerIPEnv     = "PROXY_LOAD_BALANCER_IP"
+	addressResolverEnv         = "ADDRESS_RESOLVER"
+	httpAddressResolverEnv     = "HTTP_ADDRESS_RESOLVER"
+	tcpAddressResolverEnv      = "TCP_ADDRESS_RESOLVER"
+	addressPreferHostnameEnv   = "ADDRESS_PREFER_HOSTNAME"
+	nodeAddressTypeEnv         = "NODE_ADDRESS_TYPE"
+	nodeSelectorEnv            = "NODE_SELECTOR"
+	nodePortRangeEnv           = "NODE_PORT_RANGE"
+	proxyServiceTypeEnv        = "PROXY_SERVICE_TYPE"
+	reconcileRetryEnv          = "RECONCILE_RETRY_POLICY"
+	registrationRetryEnv       = "REGISTRATION_RETRY_POLICY"
+	controllerRetriesEnv       = "CONTROLLER_RETRIES"
+	circuitBreakerThresholdEnv = "CIRCUIT_BREAKER_THRESHOLD"
+	circuitBreakerCooldownEnv  = "CIRCUIT_BREAKER_COOLDOWN"
+	settleWindowEnv            = "SETTLE_WINDOW"
+	minRolloutIntervalEnv      = "MIN_ROLLOUT_INTERVAL"
+	urgentRemovalsEnv          = "URGENT_REMOVALS"
+	rolloutDeadlineEnv         = "ROLLOUT_DEADLINE"
+	updateStrategyEnv          = "UPDATE_STRATEGY"
+	drainPeriodEnv             = "DRAIN_PERIOD"
+	isolationEnv               = "ISOLATION"
+	isolatedServicesEnv        = "ISOLATED_SERVICES"
+	proxyConfigStorageEnv      = "PROXY_CONFIG_STORAGE"
+	proxyFeaturesEnv           = "PROXY_FEATURES"
+	maxServicePortsEnv         = "MAX_SERVICE_PORTS"
+	portMappingsEnv            = "PORT_MAPPINGS"
+	httpBaseDomainEnv          = "HTTP_BASE_DOMAIN"
+	httpIngressClassEnv        = "HTTP_INGRESS_CLASS"
+	httpTLSSecretEnv           = "HTTP_TLS_SECRET"
+	httpIngressNamespaceEnv    = "HTTP_INGRESS_NAMESPACE_SELECTOR"
+	clientAddressEnv           = "CLIENT_ADDRESS"
+	httpClientAddressEnv       = "HTTP_CLIENT_ADDRESS"
+	tcpClientAddressEnv        = "TCP_CLIENT_ADDRESS"
+	dnsProviderEnv             = "DNS_PROVIDER"
+	dnsHostnameEnv             = "DNS_HOSTNAME"
+	httpDNSHostnameEnv         = "HTTP_DNS_HOSTNAME"
+	tcpDNSHostnameEnv          = "TCP_DNS_HOSTNAME"
+	dnsHostnameTemplateEnv     = "DNS_HOSTNAME_TEMPLATE"
+	dnsRegisterHostnameEnv     = "DNS_REGISTER_HOSTNAME"
+	dnsTTLEnv                  = "DNS_TTL"
+	publishPortLinksEnv        = "PUBLISH_PORT_LINKS"
 )
 
 type env struct {
@@ -36,13 +102,14 @@ type env struct {
 	value    string
 }
 
-func generateManagerOptions(namespace string, cfg *rest.Config) (opts []manager.Options) {
+// Read the env vars of the manager, exits when a required one is not set
+func readEnvs() map[string]env {
 	envs := map[string]env{
-		authURLEnv:                 {key: authURLEnv},
-		realmEnv:                   {key: realmEnv},
-		clientIDEnv:                {key: clientIDEnv},
-		clientSecretEnv:            {key: clientSecretEnv},
-		routerAddressEnv:           {key: routerAddressEnv},
+		authURLEnv:                 {key: authURLEnv, optional: true},
+		realmEnv:                   {key: realmEnv, optional: true},
+		clientIDEnv:                {key: clientIDEnv, optional: true},
+		clientSecretEnv:            {key: clientSecretEnv, optional: true},
+		routerAddressEnv:           {key: routerAddressEnv, optional: true},
 		proxyImageEnv:              {key: proxyImageEnv},
 		imagePullSecretEnv:         {key: imagePullSecretEnv, optional: true},
 		httpProxyAddressEnv:        {key: httpProxyAddressEnv, optional: true},
@@ -50,7 +117,66 @@ func generateManagerOptions(namespace string, cfg *rest.Config) (opts []manager.
 		proxyServiceAnnotationsEnv: {key: proxyServiceAnnotationsEnv, optional: true},
 		routerServerNameEnv:        {key: routerServerNameEnv, optional: true},
 		routerTransportEnv:         {key: routerTransportEnv, optional: true},
-		controllerSchemeEnv:        {key: controllerSchemeEnv},
+		controllerSchemeEnv:        {key: controllerSchemeEnv, optional: true},
+		routerDiscoveryEnv:         {key: routerDiscoveryEnv, optional: true},
+		routerTLSSecretEnv:         {key: routerTLSSecretEnv, optional: true},
+		networkPolicyEnv:           {key: networkPolicyEnv, optional: true},
+		networkPolicyCIDRsEnv:      {key: networkPolicyCIDRsEnv, optional: true},
+		networkPolicyNamespaceEnv:  {key: networkPolicyNamespaceEnv, optional: true},
+		portSourceEnv:              {key: portSourceEnv, optional: true},
+		portSourceFileEnv:          {key: portSourceFileEnv, optional: true},
+		conflictPolicyEnv:          {key: conflictPolicyEnv, optional: true},
+		portPolicyEnv:              {key: portPolicyEnv, optional: true},
+		httpPortPolicyEnv:          {key: httpPortPolicyEnv, optional: true},
+		tcpPortPolicyEnv:           {key: tcpPortPolicyEnv, optional: true},
+		deletionGuardPercentEnv:    {key: deletionGuardPercentEnv, optional: true},
+		deletionGuardObservEnv:     {key: deletionGuardObservEnv, optional: true},
+		persistStateEnv:            {key: persistStateEnv, optional: true},
+		stateStaleAfterEnv:         {key: stateStaleAfterEnv, optional: true},
+		proxyAddressEnv:            {key: proxyAddressEnv, optional: true},
+		proxyExternalIPsEnv:        {key: proxyExternalIPsEnv, optional: true},
+		proxyLoadBalancerIPEnv:     {key: proxyLoadBalancerIPEnv, optional: true},
+		addressResolverEnv:         {key: addressResolverEnv, optional: true},
+		httpAddressResolverEnv:     {key: httpAddressResolverEnv, optional: true},
+		tcpAddressResolverEnv:      {key: tcpAddressResolverEnv, optional: true},
+		addressPreferHostnameEnv:   {key: addressPreferHostnameEnv, optional: true},
+		nodeAddressTypeEnv:         {key: nodeAddressTypeEnv, optional: true},
+		nodeSelectorEnv:            {key: nodeSelectorEnv, optional: true},
+		nodePortRangeEnv:           {key: nodePortRangeEnv, optional: true},
+		proxyServiceTypeEnv:        {key: proxyServiceTypeEnv, optional: true},
+		reconcileRetryEnv:          {key: reconcileRetryEnv, optional: true},
+		registrationRetryEnv:       {key: registrationRetryEnv, optional: true},
+		controllerRetriesEnv:       {key: controllerRetriesEnv, optional: true},
+		circuitBreakerThresholdEnv: {key: circuitBreakerThresholdEnv, optional: true},
+		circuitBreakerCooldownEnv:  {key: circuitBreakerCooldownEnv, optional: true},
+		settleWindowEnv:            {key: settleWindowEnv, optional: true},
+		minRolloutIntervalEnv:      {key: minRolloutIntervalEnv, optional: true},
+		urgentRemovalsEnv:          {key: urgentRemovalsEnv, optional: true},
+		rolloutDeadlineEnv:         {key: rolloutDeadlineEnv, optional: true},
+		updateStrategyEnv:          {key: updateStrategyEnv, optional: true},
+		drainPeriodEnv:             {key: drainPeriodEnv, optional: true},
+		isolationEnv:               {key: isolationEnv, optional: true},
+		isolatedServicesEnv:        {key: isolatedServicesEnv, optional: true},
+		proxyConfigStorageEnv:      {key: proxyConfigStorageEnv, optional: true},
+		proxyFeaturesEnv:           {key: proxyFeaturesEnv, optional: true},
+		maxServicePortsEnv:         {key: maxServicePortsEnv, optional: true},
+		portMappingsEnv:            {key: portMappingsEnv, optional: true},
+		httpBaseDomainEnv:          {key: httpBaseDomainEnv, optional: true},
+		httpIngressClassEnv:        {key: httpIngressClassEnv, optional: true},
+		httpTLSSecretEnv:           {key: httpTLSSecretEnv, optional: true},
+		httpIngressNamespaceEnv:    {key: httpIngressNamespaceEnv, optional: true},
+		clientAddressEnv:           {key: clientAddressEnv, optional: true},
+		httpClientAddressEnv:       {key: httpClientAddressEnv, optional: true},
+		tcpClientAddressEnv:        {key: tcpClientAddressEnv, optional: true},
+		dnsProviderEnv:             {key: dnsProviderEnv, optional: true},
+		dnsHostnameEnv:             {key: dnsHostnameEnv, optional: true},
+		httpDNSHostnameEnv:         {key: httpDNSHostnameEnv, optional: true},
+		tcpDNSHostnameEnv:          {key: tcpDNSHostnameEnv, optional: true},
+		dnsHostnameTemplateEnv:     {key: dnsHostnameTemplateEnv, optional: true},
+		dnsRegisterHostnameEnv:     {key: dnsRegisterHostnameEnv, optional: true},
+		dnsTTLEnv:                  {key: dnsTTLEnv, optional: true},
+		publishPortLinksEnv:        {key: publishPortLinksEnv, optional: true},
+		metricsAddressEnv:          {key: metricsAddressEnv, optional: true},
 	}
 	// Read env vars
 	for _, env := range envs {
@@ -62,25 +188,87 @@ func generateManagerOptions(namespace string, cfg *rest.Config) (opts []manager.
 		// Store result for later
 		envs[env.key] = env
 	}
+	return envs
+}
+
+func generateManagerOptions(envs map[string]env, namespace string, cfg *rest.Config, dryRun bool) (opts []manager.Options) {
+	// Controller credentials are only required by the Controller port source, file and CRD sources may run without a Controller
+	portSource := strings.ToLower(envs[portSourceEnv].value)
+	if portSource == "" || portSource == manager.PortSourceController || envs[authURLEnv].value != "" {
+		for _, key := range []string{authURLEnv, realmEnv, clientIDEnv, clientSecretEnv, controllerSchemeEnv} {
+			if envs[key].value == "" {
+				log.Error(nil, key+" env var not set")
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Router address is only required when it is not discovered from the Controller
+	routerDiscovery := strings.EqualFold(envs[routerDiscoveryEnv].value, "true")
+	if envs[routerAddressEnv].value == "" && !routerDiscovery {
+		log.Error(nil, routerAddressEnv+" env var not set")
+		os.Exit(1)
+	}
 
 	opt := manager.Options{
-		Namespace:               namespace,
-		AuthURL:                 envs[authURLEnv].value,
-		Realm:                   envs[realmEnv].value,
-		ClientID:                envs[clientIDEnv].value,
-		ClientSecret:            envs[clientSecretEnv].value,
-		ProxyImage:              envs[proxyImageEnv].value,
-		ImagePullSecret:         envs[imagePullSecretEnv].value,
-		ProxyServiceType:        "LoadBalancer",
-		ProxyServiceAnnotations: make(map[string]string),
-		ProxyExternalAddress:    "",
-		ProtocolFilter:          "",
-		ProxyName:               "pot-proxy", // TODO: Fix this default, e.g. iofogctl tests get svc name
-		RouterAddress:           envs[routerAddressEnv].value,
-		ControllerScheme:        envs[controllerSchemeEnv].value,
-		RouterServerName:        "",
-		RouterTransport:         "",
-		Config:                  cfg,
+		Namespace:                 namespace,
+		AuthURL:                   envs[authURLEnv].value,
+		Realm:                     envs[realmEnv].value,
+		ClientID:                  envs[clientIDEnv].value,
+		ClientSecret:              envs[clientSecretEnv].value,
+		ProxyImage:                envs[proxyImageEnv].value,
+		ImagePullSecret:           envs[imagePullSecretEnv].value,
+		ProxyServiceType:          envs[proxyServiceTypeEnv].value,
+		ProxyServiceAnnotations:   make(map[string]string),
+		ProxyExternalAddress:      envs[proxyAddressEnv].value,
+		ProxyExternalIPs:          parseList(envs[proxyExternalIPsEnv]),
+		ProxyLoadBalancerIP:       envs[proxyLoadBalancerIPEnv].value,
+		AddressResolver:           strings.ToLower(envs[addressResolverEnv].value),
+		AddressPreferHostname:     strings.EqualFold(envs[addressPreferHostnameEnv].value, "true"),
+		NodeAddressType:           envs[nodeAddressTypeEnv].value,
+		ProtocolFilter:            "",
+		ProxyName:                 "pot-proxy", // TODO: Fix this default, e.g. iofogctl tests get svc name
+		RouterAddress:             envs[routerAddressEnv].value,
+		RouterDiscovery:           routerDiscovery,
+		ControllerScheme:          envs[controllerSchemeEnv].value,
+		RouterServerName:          "",
+		RouterTransport:           "",
+		RouterTLSSecret:           envs[routerTLSSecretEnv].value,
+		ProxyNetworkPolicy:        strings.EqualFold(envs[networkPolicyEnv].value, "true"),
+		DryRun:                    dryRun,
+		PortSource:                portSource,
+		PortSourceFile:            envs[portSourceFileEnv].value,
+		ConflictPolicy:            strings.ToLower(envs[conflictPolicyEnv].value),
+		DeletionGuardPercent:      parseInt(envs[deletionGuardPercentEnv]),
+		DeletionGuardObservations: parseInt(envs[deletionGuardObservEnv]),
+		PersistState:              strings.EqualFold(envs[persistStateEnv].value, "true"),
+		StateStaleAfter:           parseDuration(envs[stateStaleAfterEnv]),
+		ReconcileRetry:            parseRetryPolicy(envs[reconcileRetryEnv]),
+		RegistrationRetry:         parseRetryPolicy(envs[registrationRetryEnv]),
+		ControllerRetries:         parseInt(envs[controllerRetriesEnv]),
+		CircuitBreakerThreshold:   parseInt(envs[circuitBreakerThresholdEnv]),
+		CircuitBreakerCooldown:    parseDuration(envs[circuitBreakerCooldownEnv]),
+		SettleWindow:              parseDuration(envs[settleWindowEnv]),
+		MinRolloutInterval:        parseDuration(envs[minRolloutIntervalEnv]),
+		UrgentRemovals:            strings.EqualFold(envs[urgentRemovalsEnv].value, "true"),
+		RolloutDeadline:           parseDuration(envs[rolloutDeadlineEnv]),
+		UpdateStrategy:            strings.ToLower(envs[updateStrategyEnv].value),
+		DrainPeriod:               parseDuration(envs[drainPeriodEnv]),
+		Isolation:                 strings.ToLower(envs[isolationEnv].value),
+		IsolatedServices:          strings.EqualFold(envs[isolatedServicesEnv].value, "true"),
+		ProxyConfigStorage:        strings.ToLower(envs[proxyConfigStorageEnv].value),
+		ProxyFeatures:             parseList(envs[proxyFeaturesEnv]),
+		MaxServicePorts:           parseInt(envs[maxServicePortsEnv]),
+		HTTPBaseDomain:            strings.ToLower(envs[httpBaseDomainEnv].value),
+		HTTPIngressClass:          envs[httpIngressClassEnv].value,
+		HTTPTLSSecret:             envs[httpTLSSecretEnv].value,
+		DNSProvider:               strings.ToLower(envs[dnsProviderEnv].value),
+		DNSHostname:               strings.ToLower(envs[dnsHostnameEnv].value),
+		DNSHostnameTemplate:       envs[dnsHostnameTemplateEnv].value,
+		DNSRegisterHostname:       strings.EqualFold(envs[dnsRegisterHostnameEnv].value, "true"),
+		DNSTTL:                    parseInt(envs[dnsTTLEnv]),
+		PublishPortLinks:          strings.EqualFold(envs[publishPortLinksEnv].value, "true"),
+		Config:                    cfg,
 	}
 
 	// Set routerServerName if present
@@ -104,25 +292,183 @@ func generateManagerOptions(namespace string, cfg *rest.Config) (opts []manager.
 		opt.ProxyServiceAnnotations = annotationsMap
 	}
 
+	// Set NetworkPolicy ingress restrictions if present
+	opt.NetworkPolicyCIDRs = parseList(envs[networkPolicyCIDRsEnv])
+	if selector := envs[networkPolicyNamespaceEnv].value; selector != "" {
+		if err := json.Unmarshal([]byte(selector), &opt.NetworkPolicyNamespaceSelector); err != nil {
+			log.Error(err, "Failed to unmarshal NetworkPolicy namespace selector")
+			os.Exit(1)
+		}
+	}
+	if selector := envs[httpIngressNamespaceEnv].value; selector != "" {
+		if err := json.Unmarshal([]byte(selector), &opt.HTTPIngressNamespaceSelector); err != nil {
+			log.Error(err, "Failed to unmarshal Ingress controller namespace selector")
+			os.Exit(1)
+		}
+	}
+
+	// Set node selector of the nodeport address resolver if present
+	if selector := envs[nodeSelectorEnv].value; selector != "" {
+		if err := json.Unmarshal([]byte(selector), &opt.NodeSelector); err != nil {
+			log.Error(err, "Failed to unmarshal node selector")
+			os.Exit(1)
+		}
+	}
+
+	// Set node port range of the cluster if present
+	if portRange := envs[nodePortRangeEnv].value; portRange != "" {
+		if err := json.Unmarshal([]byte(portRange), &opt.NodePortRange); err != nil {
+			log.Error(err, "Failed to unmarshal node port range")
+			os.Exit(1)
+		}
+	}
+
+	// Set external port mappings if present
+	if mappings := envs[portMappingsEnv].value; mappings != "" {
+		if err := json.Unmarshal([]byte(mappings), &opt.PortMappings); err != nil {
+			log.Error(err, "Failed to unmarshal port mappings")
+			os.Exit(1)
+		}
+	}
+
+	// Set port policy if present
+	if policy := envs[portPolicyEnv].value; policy != "" {
+		opt.PortPolicy = parsePortPolicy(policy)
+	}
+
+	// Set client address rules if present
+	if rules := envs[clientAddressEnv].value; rules != "" {
+		opt.ClientAddress = parseClientAddress(rules)
+	}
+
 	opts = append(opts, opt)
 	if envs[httpProxyAddressEnv].value != "" && envs[tcpProxyAddressEnv].value != "" {
 		// Update first opt
-		opts[0].ProxyServiceType = "ClusterIP"
+		opts[0].ProxyServiceType = getSplitServiceType(opt.ProxyServiceType, envs[httpAddressResolverEnv].value)
 		opts[0].ProtocolFilter = "http"
 		opts[0].ProxyName = "http-proxy"
 		opts[0].ProxyExternalAddress = envs[httpProxyAddressEnv].value
+		if policy := envs[httpPortPolicyEnv].value; policy != "" {
+			opts[0].PortPolicy = parsePortPolicy(policy)
+		}
+		if rules := envs[httpClientAddressEnv].value; rules != "" {
+			opts[0].ClientAddress = parseClientAddress(rules)
+		}
+		if hostname := envs[httpDNSHostnameEnv].value; hostname != "" {
+			opts[0].DNSHostname = strings.ToLower(hostname)
+		}
+		if resolver := envs[httpAddressResolverEnv].value; resolver != "" {
+			opts[0].AddressResolver = strings.ToLower(resolver)
+		}
 		// Create second opt
-		opt.ProxyServiceType = "ClusterIP"
+		opt.ProxyServiceType = getSplitServiceType(opt.ProxyServiceType, envs[tcpAddressResolverEnv].value)
 		opt.ProtocolFilter = "tcp"
 		opt.ProxyName = "tcp-proxy"
 		opt.ProxyExternalAddress = envs[tcpProxyAddressEnv].value
+		if policy := envs[tcpPortPolicyEnv].value; policy != "" {
+			opt.PortPolicy = parsePortPolicy(policy)
+		}
+		if rules := envs[tcpClientAddressEnv].value; rules != "" {
+			opt.ClientAddress = parseClientAddress(rules)
+		}
+		if hostname := envs[tcpDNSHostnameEnv].value; hostname != "" {
+			opt.DNSHostname = strings.ToLower(hostname)
+		}
+		if resolver := envs[tcpAddressResolverEnv].value; resolver != "" {
+			opt.AddressResolver = strings.ToLower(resolver)
+		}
 		opts = append(opts, opt)
 	}
 	return opts
 }
 
-func generateManagers(namespace string, cfg *rest.Config) (mgrs []*manager.Manager) {
-	opts := generateManagerOptions(namespace, cfg)
+func parseInt(env env) int {
+	if env.value == "" {
+		return 0
+	}
+	value, err := strconv.Atoi(env.value)
+	if err != nil {
+		log.Error(err, env.key+" env var is not an integer")
+		os.Exit(1)
+	}
+	return value
+}
+
+// Comma separated list, empty values are dropped
+func parseList(env env) (list []string) {
+	for _, item := range strings.Split(env.value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return
+}
+
+func parseDuration(env env) time.Duration {
+	if env.value == "" {
+		return 0
+	}
+	value, err := time.ParseDuration(env.value)
+	if err != nil {
+		log.Error(err, env.key+" env var is not a duration")
+		os.Exit(1)
+	}
+	return value
+}
+
+// JSON retry policy with durations as strings, e.g. {"baseDelay": "5s", "maxDelay": "5m", "jitter": 0.2, "maxRetries": 0}
+func parseRetryPolicy(policyEnv env) (policy manager.RetryPolicy) {
+	if policyEnv.value == "" {
+		return
+	}
+	value := struct {
+		BaseDelay  string  `json:"baseDelay"`
+		MaxDelay   string  `json:"maxDelay"`
+		Jitter     float64 `json:"jitter"`
+		MaxRetries int     `json:"maxRetries"`
+	}{}
+	if err := json.Unmarshal([]byte(policyEnv.value), &value); err != nil {
+		log.Error(err, "Failed to unmarshal "+policyEnv.key)
+		os.Exit(1)
+	}
+	policy.BaseDelay = parseDuration(env{key: policyEnv.key + " baseDelay", value: value.BaseDelay})
+	policy.MaxDelay = parseDuration(env{key: policyEnv.key + " maxDelay", value: value.MaxDelay})
+	policy.Jitter = value.Jitter
+	policy.MaxRetries = value.MaxRetries
+	return
+}
+
+// Split proxies are reached at their configured address through a ClusterIP Service unless a type is configured
+// or their address resolver requires one
+func getSplitServiceType(serviceType, resolver string) string {
+	if serviceType != "" {
+		return serviceType
+	}
+	switch strings.ToLower(resolver) {
+	case manager.AddressResolverLoadBalancer, manager.AddressResolverNodePort, manager.AddressResolverClusterIP:
+		return ""
+	}
+	return "ClusterIP"
+}
+
+func parsePortPolicy(value string) (policy manager.PortPolicy) {
+	if err := json.Unmarshal([]byte(value), &policy); err != nil {
+		log.Error(err, "Failed to unmarshal port policy")
+		os.Exit(1)
+	}
+	return
+}
+
+func parseClientAddress(value string) (rules []manager.ClientAddressRule) {
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		log.Error(err, "Failed to unmarshal client address rules")
+		os.Exit(1)
+	}
+	return
+}
+
+func generateManagers(envs map[string]env, namespace string, cfg *rest.Config, dryRun bool) (mgrs []*manager.Manager) {
+	opts := generateManagerOptions(envs, namespace, cfg, dryRun)
 	// No external address provided, Manager will create Proxy LoadBalancer and single Deployment
 	for idx := range opts {
 		opt := &opts[idx]
@@ -149,13 +495,59 @@ func getWatchNamespace() (ns string) {
 	return
 }
 
+// Print the changes each manager would make in one reconcile cycle
+func printPlans(mgrs []*manager.Manager, output string) {
+	plans := make([]*manager.Plan, 0, len(mgrs))
+	for _, mgr := range mgrs {
+		plan, err := mgr.Plan()
+		handleErr(err, "Failed to plan changes")
+		plans = append(plans, plan)
+	}
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		handleErr(encoder.Encode(plans), "Failed to encode plan")
+		return
+	}
+	for _, plan := range plans {
+		fmt.Print(plan.String())
+	}
+}
+
 func main() {
+	dryRun := flag.Bool("dry-run", false, "Log the changes of each reconcile cycle without applying them")
+	output := flag.String("output", "text", "Output format of the plan command, text or json")
+	flag.Parse()
+
+	// Plan subcommand performs a single dry-run cycle, flags may follow it
+	planMode := flag.Arg(0) == "plan"
+	if planMode {
+		handleErr(flag.CommandLine.Parse(flag.Args()[1:]), "")
+		*dryRun = true
+	}
+	if *output != "text" && *output != "json" {
+		handleErr(fmt.Errorf("unsupported output format %s", *output), "")
+	}
+
 	// Get a config to talk to the apiserver
 	cfg, err := config.GetConfig()
 	handleErr(err, "")
 
 	// Instantiate Manager(s)
-	mgrs := generateManagers(getWatchNamespace(), cfg)
+	envs := readEnvs()
+	mgrs := generateManagers(envs, getWatchNamespace(), cfg, *dryRun)
+
+	if planMode {
+		printPlans(mgrs, *output)
+		return
+	}
+
+	// Serve metrics if requested
+	if addr := envs[metricsAddressEnv].value; addr != "" {
+		go func() {
+			handleErr(http.ListenAndServe(addr, expvar.Handler()), "Failed to serve metrics")
+		}()
+	}
 
 	// Run Managers
 	for _, mgr := range mgrs {
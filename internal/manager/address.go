@@ -0,0 +1,95 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Resolve the proxy address with the configured strategy, empty while it is not available yet
+func (mgr *Manager) resolveProxyAddress() (string, error) {
+	if mgr.opt.DNSRegisterHostname {
+		return mgr.opt.DNSHostname, nil
+	}
+	var found *corev1.Service
+	svc := corev1.Service{}
+	proxyKey := k8sclient.ObjectKey{
+		Name:      mgr.opt.ProxyName,
+		Namespace: mgr.opt.Namespace,
+	}
+	if err := mgr.k8sClient.Get(context.TODO(), proxyKey, &svc); err == nil {
+		found = &svc
+	} else if !k8serrors.IsNotFound(err) {
+		return "", err
+	}
+	return mgr.resolver.resolve(found)
+}
+
+// Compare the proxy address with what was registered and what the Controller holds, re-register when they diverge
+func (mgr *Manager) trackProxyAddress() error {
+	if !mgr.usesController() {
+		return nil
+	}
+	addr, err := mgr.resolveProxyAddress()
+	if err != nil || addr == "" {
+		return err
+	}
+
+	registered := mgr.state.address
+	if registered != addr {
+		// Registration of this address is still in progress
+		if mgr.requestedAddress == addr {
+			return nil
+		}
+		if registered != "" {
+			msg := fmt.Sprintf("Proxy address changed from %s to %s", registered, addr)
+			mgr.log.Info(msg)
+			mgr.recordEvent(corev1.EventTypeNormal, "ProxyAddressChanged", msg)
+		}
+		mgr.requestAddress(addr)
+		return nil
+	}
+
+	// Registered address may have been edited on the Controller
+	if mgr.ioClient == nil {
+		return nil
+	}
+	value, _, err := mgr.getControllerConfig(defaultProxyHostKey)
+	if err != nil {
+		return err
+	}
+	if value == addr {
+		mgr.reportedDrift = ""
+		return nil
+	}
+	msg := fmt.Sprintf("Controller %s is %q, expected %s", defaultProxyHostKey, value, addr)
+	if mgr.reportedDrift != msg {
+		mgr.log.Info(msg)
+		mgr.recordEvent(corev1.EventTypeWarning, "ProxyAddressDrift", msg)
+		mgr.reportedDrift = msg
+	}
+	mgr.requestAddress(addr)
+	return nil
+}
+
+func (mgr *Manager) requestAddress(addr string) {
+	mgr.requestedAddress = addr
+	metrics.addressRegistrations.Add(mgr.opt.ProxyName, 1)
+	mgr.registerAddress(addr)
+}
@@ -0,0 +1,148 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+func TestTrackProxyAddress(t *testing.T) {
+	testCases := []struct {
+		name            string
+		ingress         string
+		registered      string
+		controllerValue string // Value of default-proxy-host, the Controller is not read when empty
+		expected        string // Requested address, empty when no registration is requested
+		event           string
+	}{
+		{
+			name: "pending load balancer",
+		},
+		{
+			name:     "load balancer address",
+			ingress:  "1.1.1.1",
+			expected: "1.1.1.1",
+		},
+		{
+			name:       "changed load balancer address",
+			ingress:    "2.2.2.2",
+			registered: "1.1.1.1",
+			expected:   "2.2.2.2",
+			event:      "ProxyAddressChanged",
+		},
+		{
+			name:            "controller value edited by someone else",
+			ingress:         "2.2.2.2",
+			registered:      "2.2.2.2",
+			controllerValue: "edited",
+			expected:        "2.2.2.2",
+			event:           "ProxyAddressDrift",
+		},
+		{
+			name:            "controller value matches",
+			ingress:         "2.2.2.2",
+			registered:      "2.2.2.2",
+			controllerValue: "2.2.2.2",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := &fakePortSource{}
+			k8sClient := newFakeClient()
+			mgr := newTestManager(newTestOptions(), k8sClient, source)
+			source.set(newPublicPort("a", "tcp", 5000))
+			if err := mgr.run(); err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.ingress != "" {
+				_, svc := getProxyObjects(t, mgr)
+				svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: tc.ingress}}
+				if err := k8sClient.Update(context.TODO(), svc); err != nil {
+					t.Fatal(err)
+				}
+			}
+			mgr.state.address = tc.registered
+			if tc.controllerValue != "" {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					fmt.Fprintf(w, `[{"key":%q,"value":%q}]`, defaultProxyHostKey, tc.controllerValue)
+				}))
+				defer server.Close()
+				baseURL, _ := url.Parse(server.URL)
+				mgr.ioClient = ioclient.New(ioclient.Options{BaseURL: baseURL})
+			}
+
+			if err := mgr.trackProxyAddress(); err != nil {
+				t.Fatal(err)
+			}
+			if mgr.requestedAddress != tc.expected {
+				t.Errorf("Expected registration of %q, got %q", tc.expected, mgr.requestedAddress)
+			}
+			if tc.event != "" && len(getEvents(k8sClient, tc.event)) != 1 {
+				t.Errorf("Expected %s Event", tc.event)
+			}
+		})
+	}
+}
+
+func TestProxyAddressDriftEvents(t *testing.T) {
+	opt := newTestOptions()
+	opt.AddressResolver = AddressResolverStatic
+	opt.ProxyExternalAddress = "2.2.2.2"
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, &fakePortSource{})
+	mgr.state.address = "2.2.2.2"
+	controllerValue := "edited"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[{"key":%q,"value":%q}]`, defaultProxyHostKey, controllerValue)
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL)
+	mgr.ioClient = ioclient.New(ioclient.Options{BaseURL: baseURL})
+	track := func(cycles int) {
+		t.Helper()
+		for idx := 0; idx < cycles; idx++ {
+			if err := mgr.trackProxyAddress(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Persisting drift is reported once
+	track(3)
+	if events := getEvents(k8sClient, "ProxyAddressDrift"); len(events) != 1 {
+		t.Errorf("Expected a single drift Event, got %d", len(events))
+	}
+
+	// Drift after the values agreed again is reported anew
+	controllerValue = "2.2.2.2"
+	track(1)
+	if mgr.reportedDrift != "" {
+		t.Errorf("Expected reported drift to be cleared, got %s", mgr.reportedDrift)
+	}
+	controllerValue = "edited"
+	track(2)
+	if events := getEvents(k8sClient, "ProxyAddressDrift"); len(events) != 2 {
+		t.Errorf("Expected a second drift Event, got %d", len(events))
+	}
+}
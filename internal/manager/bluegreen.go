@@ -0,0 +1,407 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Strategies to update the proxy Deployment
+const (
+	UpdateStrategyRolling   = "rolling"
+	UpdateStrategyBlueGreen = "bluegreen"
+)
+
+const (
+	colorLabel = "datasance.com/proxy-color"
+	colorBlue  = "blue"
+	colorGreen = "green"
+	// Progress of the switch, kept on the proxy Service so that a restarted manager resumes it
+	blueGreenAnnotation = "datasance.com/proxy-blue-green"
+)
+
+// Blue/green proxies, the Service selects the active color
+// New config is rolled out to the standby color, traffic is switched once it is ready and the previous Deployment is drained
+type blueGreenState struct {
+	active       string // Empty when the Service selects by name only, e.g. a proxy created before blue/green was enabled
+	standby      string // Color being prepared, empty when none
+	standbySince time.Time
+	draining     string // Name of the Deployment being drained, empty when none
+	drainSince   time.Time
+	deferred     bool // Update waiting for the standby color to finish draining
+	migrating    bool // Standby copies a proxy created before blue/green was enabled
+}
+
+// Annotation of blueGreenState, the active color is held by the Service selector
+type persistedBlueGreen struct {
+	Standby      string     `json:"standby,omitempty"`
+	StandbySince *time.Time `json:"standbySince,omitempty"`
+	Draining     string     `json:"draining,omitempty"`
+	DrainSince   *time.Time `json:"drainSince,omitempty"`
+	Deferred     bool       `json:"deferred,omitempty"`
+	Migrating    bool       `json:"migrating,omitempty"`
+}
+
+func getOptionalTime(value time.Time) *time.Time {
+	if value.IsZero() {
+		return nil
+	}
+	return &value
+}
+
+func (state *blueGreenState) encode() string {
+	encoded, _ := json.Marshal(persistedBlueGreen{
+		Standby:      state.standby,
+		StandbySince: getOptionalTime(state.standbySince),
+		Draining:     state.draining,
+		DrainSince:   getOptionalTime(state.drainSince),
+		Deferred:     state.deferred,
+		Migrating:    state.migrating,
+	})
+	return string(encoded)
+}
+
+func (state *blueGreenState) decode(value string) error {
+	persisted := persistedBlueGreen{}
+	if err := json.Unmarshal([]byte(value), &persisted); err != nil {
+		return err
+	}
+	state.standby = persisted.Standby
+	state.draining = persisted.Draining
+	if persisted.StandbySince != nil {
+		state.standbySince = *persisted.StandbySince
+	}
+	if persisted.DrainSince != nil {
+		state.drainSince = *persisted.DrainSince
+	}
+	state.deferred = persisted.Deferred
+	state.migrating = persisted.Migrating
+	return nil
+}
+
+func (mgr *Manager) isBlueGreen() bool {
+	return mgr.opt.UpdateStrategy == UpdateStrategyBlueGreen
+}
+
+// Name of the Deployment currently serving traffic
+func (mgr *Manager) getProxyDeploymentName() string {
+	if !mgr.isBlueGreen() || mgr.blueGreen.active == "" {
+		return mgr.opt.ProxyName
+	}
+	return getColoredName(mgr.opt.ProxyName, mgr.blueGreen.active)
+}
+
+func getColoredName(name, color string) string {
+	return name + "-" + color
+}
+
+func getOtherColor(color string) string {
+	if color == colorBlue {
+		return colorGreen
+	}
+	return colorBlue
+}
+
+// Active color as selected by the existing Service
+func (mgr *Manager) detectActiveColor() error {
+	svc := corev1.Service{}
+	proxyKey := k8sclient.ObjectKey{
+		Name:      mgr.opt.ProxyName,
+		Namespace: mgr.opt.Namespace,
+	}
+	if err := mgr.k8sClient.Get(context.TODO(), proxyKey, &svc); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	mgr.blueGreen.active = svc.Spec.Selector[colorLabel]
+	if value, exists := svc.Annotations[blueGreenAnnotation]; exists {
+		if err := mgr.blueGreen.decode(value); err != nil {
+			return fmt.Errorf("could not decode blue/green state of Service %s: %s", svc.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// Write the state to the proxy Service when it changed outside of a Service update
+func (mgr *Manager) saveBlueGreen() error {
+	svc, err := mgr.getService(mgr.opt.ProxyName)
+	if err != nil || svc == nil {
+		return err
+	}
+	value := mgr.blueGreen.encode()
+	if svc.Annotations[blueGreenAnnotation] == value {
+		return nil
+	}
+	if svc.Annotations == nil {
+		svc.Annotations = make(map[string]string)
+	}
+	svc.Annotations[blueGreenAnnotation] = value
+	return mgr.update(svc)
+}
+
+// Desired proxy Deployment of a color, pods keep the proxy name label so that the NetworkPolicy covers both colors
+func (mgr *Manager) newColoredProxyDeployment(config, color string) *appsv1.Deployment {
+	dep := mgr.newProxyDeployment(config)
+	dep.Name = getColoredName(mgr.opt.ProxyName, color)
+	labels := map[string]string{
+		"name":     mgr.opt.ProxyName,
+		colorLabel: color,
+	}
+	dep.Labels = labels
+	dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+	dep.Spec.Template.Labels = labels
+	return dep
+}
+
+func (mgr *Manager) getDeployment(name string) (*appsv1.Deployment, error) {
+	dep := &appsv1.Deployment{}
+	key := k8sclient.ObjectKey{
+		Name:      name,
+		Namespace: mgr.opt.Namespace,
+	}
+	if err := mgr.k8sClient.Get(context.TODO(), key, dep); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return dep, nil
+}
+
+// Bring the active Deployment in line with the cache, preparing the standby color when it differs
+func (mgr *Manager) updateProxyBlueGreen() error {
+	config := mgr.renderProxyConfig(mgr.cache)
+	if config == "" {
+		return mgr.deleteBlueGreenDeployments()
+	}
+	if err := mgr.storeProxyConfig(config); err != nil {
+		return err
+	}
+
+	active, err := mgr.getDeployment(mgr.getProxyDeploymentName())
+	if err != nil {
+		return err
+	}
+	if active == nil && mgr.blueGreen.active == "" {
+		// Nothing serves traffic yet, create the active color directly
+		mgr.blueGreen.active = colorBlue
+		dep := mgr.newColoredProxyDeployment(config, colorBlue)
+		mgr.setOwnerReference(dep)
+		return mgr.create(dep)
+	}
+	if active != nil && mgr.blueGreen.active == "" {
+		return mgr.migrateToBlueGreen(active)
+	}
+	if active != nil && mgr.blueGreen.active != "" {
+		if !reconcileProxyDeployment(active.DeepCopy(), mgr.newColoredProxyDeployment(config, mgr.blueGreen.active)) {
+			// Active is up to date, a standby being prepared is obsolete
+			return mgr.cancelStandby()
+		}
+	}
+
+	// Prepare the standby color
+	color := getOtherColor(mgr.blueGreen.active)
+	standbyName := getColoredName(mgr.opt.ProxyName, color)
+	if mgr.blueGreen.draining == standbyName {
+		mgr.log.Info("Waiting for previous Proxy to drain before preparing an update", "deployment", standbyName)
+		mgr.blueGreen.deferred = true
+		return nil
+	}
+	desired := mgr.newColoredProxyDeployment(config, color)
+	standby, err := mgr.getDeployment(standbyName)
+	if err != nil {
+		return err
+	}
+	if standby == nil {
+		mgr.setOwnerReference(desired)
+		err = mgr.create(desired)
+	} else if reconcileProxyDeployment(standby, desired) {
+		err = mgr.update(standby)
+	} else if mgr.blueGreen.standby == color {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	mgr.log.Info("Preparing standby Proxy", "color", color)
+	mgr.blueGreen.standby = color
+	mgr.blueGreen.standbySince = time.Now()
+	return nil
+}
+
+// A proxy created before blue/green was enabled is selected by name only, which would also select a standby with new config
+// Its config is first copied to the blue color, the Service selects blue once it is ready and the update follows as usual
+func (mgr *Manager) migrateToBlueGreen(legacy *appsv1.Deployment) error {
+	if mgr.blueGreen.standby == colorBlue {
+		return nil
+	}
+	config, err := mgr.readProxyConfig(legacy)
+	if err != nil {
+		return err
+	}
+	dep := mgr.newColoredProxyDeployment(config, colorBlue)
+	existing, err := mgr.getDeployment(dep.Name)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		mgr.setOwnerReference(dep)
+		err = mgr.create(dep)
+	} else if reconcileProxyDeployment(existing, dep) {
+		err = mgr.update(existing)
+	}
+	if err != nil {
+		return err
+	}
+	mgr.log.Info("Copying Proxy to the blue color before switching to blue/green updates", "deployment", legacy.Name)
+	mgr.blueGreen.standby = colorBlue
+	mgr.blueGreen.standbySince = time.Now()
+	mgr.blueGreen.migrating = true
+	return nil
+}
+
+func (mgr *Manager) cancelStandby() error {
+	if mgr.blueGreen.standby == "" {
+		return nil
+	}
+	name := getColoredName(mgr.opt.ProxyName, mgr.blueGreen.standby)
+	mgr.blueGreen.standby = ""
+	return mgr.deleteDeployment(name)
+}
+
+func (mgr *Manager) deleteDeployment(name string) error {
+	dep := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		Name:      name,
+		Namespace: mgr.opt.Namespace,
+	}}
+	if err := mgr.delete(dep); err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (mgr *Manager) deleteBlueGreenDeployments() error {
+	for _, name := range []string{
+		mgr.opt.ProxyName,
+		getColoredName(mgr.opt.ProxyName, colorBlue),
+		getColoredName(mgr.opt.ProxyName, colorGreen),
+	} {
+		if err := mgr.deleteDeployment(name); err != nil {
+			return err
+		}
+	}
+	mgr.blueGreen = blueGreenState{}
+	return nil
+}
+
+// Switch traffic to a ready standby and delete drained Deployments
+func (mgr *Manager) checkBlueGreen() error {
+	if !mgr.isBlueGreen() {
+		return nil
+	}
+	if err := mgr.checkStandby(); err != nil {
+		return err
+	}
+	if err := mgr.checkDraining(); err != nil {
+		return err
+	}
+	return mgr.saveBlueGreen()
+}
+
+func (mgr *Manager) checkDraining() error {
+	if mgr.blueGreen.draining == "" || time.Since(mgr.blueGreen.drainSince) < mgr.opt.DrainPeriod {
+		return nil
+	}
+	mgr.log.Info("Deleting drained Proxy", "deployment", mgr.blueGreen.draining)
+	if err := mgr.deleteDeployment(mgr.blueGreen.draining); err != nil {
+		return err
+	}
+	mgr.blueGreen.draining = ""
+	if mgr.blueGreen.deferred {
+		mgr.blueGreen.deferred = false
+		return mgr.updateProxy()
+	}
+	return nil
+}
+
+func (mgr *Manager) checkStandby() error {
+	if mgr.blueGreen.standby == "" {
+		return nil
+	}
+	standby, err := mgr.getDeployment(getColoredName(mgr.opt.ProxyName, mgr.blueGreen.standby))
+	if err != nil {
+		return err
+	}
+	if standby == nil {
+		mgr.blueGreen.standby = ""
+		mgr.blueGreen.migrating = false
+		return nil
+	}
+	if !isRolloutComplete(standby, standby.Generation) {
+		if time.Since(mgr.blueGreen.standbySince) < mgr.opt.RolloutDeadline || mgr.opt.RolloutDeadline < 0 {
+			return nil
+		}
+		msg := fmt.Sprintf("Standby Proxy %s did not become ready within %s, traffic stays on %s", standby.Name, mgr.opt.RolloutDeadline, mgr.getProxyDeploymentName())
+		mgr.log.Info(msg)
+		mgr.recordEvent(corev1.EventTypeWarning, "ProxyRolloutFailed", msg)
+		metrics.rolloutsFailed.Add(mgr.opt.ProxyName, 1)
+		mgr.blueGreen.migrating = false
+		return mgr.cancelStandby()
+	}
+
+	// Switch every Service to the standby
+	for _, name := range mgr.getProxyServiceNames() {
+		svc, err := mgr.getService(name)
+		if err != nil {
+			return err
+		}
+		if svc == nil {
+			continue
+		}
+		svc.Spec.Selector = map[string]string{
+			"name":     mgr.opt.ProxyName,
+			colorLabel: mgr.blueGreen.standby,
+		}
+		if err := mgr.update(svc); err != nil {
+			return err
+		}
+	}
+	previous := mgr.getProxyDeploymentName()
+	msg := fmt.Sprintf("Switched Proxy traffic from %s to %s, draining %s for %s", previous, standby.Name, previous, mgr.opt.DrainPeriod)
+	mgr.log.Info(msg)
+	mgr.recordEvent(corev1.EventTypeNormal, "ProxySwitched", msg)
+	metrics.rolloutsCompleted.Add(mgr.opt.ProxyName, 1)
+	mgr.blueGreen.active = mgr.blueGreen.standby
+	mgr.blueGreen.standby = ""
+	mgr.blueGreen.draining = previous
+	mgr.blueGreen.drainSince = time.Now()
+
+	// The update held back by the migration is prepared on the other color
+	if mgr.blueGreen.migrating {
+		mgr.blueGreen.migrating = false
+		return mgr.updateProxy()
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+func TestReconcileBlueGreen(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.UpdateStrategy = UpdateStrategyBlueGreen
+	opt.DrainPeriod = time.Hour
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, source)
+	getDeployment := func(color string) *appsv1.Deployment {
+		dep, err := mgr.getDeployment(getColoredName(opt.ProxyName, color))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dep
+	}
+	getSelectedColor := func() string {
+		_, svc := getProxyObjects(t, mgr)
+		return svc.Spec.Selector[colorLabel]
+	}
+
+	source.set(newPublicPort("a", "tcp", 5000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if getDeployment(colorBlue) == nil || getSelectedColor() != colorBlue {
+		t.Fatalf("Expected blue Proxy to serve traffic")
+	}
+
+	// Update is prepared on green while blue keeps serving
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	green := getDeployment(colorGreen)
+	if green == nil {
+		t.Fatalf("Expected green standby Proxy")
+	}
+	if err := mgr.checkBlueGreen(); err != nil {
+		t.Fatal(err)
+	}
+	if getSelectedColor() != colorBlue {
+		t.Fatalf("Expected traffic to stay on blue until green is ready")
+	}
+
+	// Green is ready, traffic switches and blue drains
+	green.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if err := k8sClient.Update(context.TODO(), green); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.checkBlueGreen(); err != nil {
+		t.Fatal(err)
+	}
+	if getSelectedColor() != colorGreen || getDeployment(colorBlue) == nil {
+		t.Fatalf("Expected traffic on green with blue draining")
+	}
+	mgr.blueGreen.drainSince = time.Now().Add(-2 * time.Hour)
+	if err := mgr.checkBlueGreen(); err != nil {
+		t.Fatal(err)
+	}
+	if getDeployment(colorBlue) != nil {
+		t.Errorf("Expected blue Proxy to be deleted after draining")
+	}
+
+	// Restarted manager reads the active color
+	restarted := newTestManager(opt, k8sClient, source)
+	if err := restarted.generateCache(); err != nil {
+		t.Fatal(err)
+	}
+	if restarted.blueGreen.active != colorGreen || len(restarted.cache) != 2 {
+		t.Errorf("Expected cache from green Proxy, got %s %v", restarted.blueGreen.active, restarted.cache)
+	}
+}
+
+func TestBlueGreenRestart(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.UpdateStrategy = UpdateStrategyBlueGreen
+	opt.DrainPeriod = time.Hour
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, source)
+
+	source.set(newPublicPort("a", "tcp", 5000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	setReady(t, k8sClient, mgr, getColoredName(opt.ProxyName, colorGreen))
+	if err := mgr.checkBlueGreen(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restarted manager resumes draining blue
+	restarted := newTestManager(opt, k8sClient, source)
+	if err := restarted.generateCache(); err != nil {
+		t.Fatal(err)
+	}
+	if restarted.blueGreen.draining != getColoredName(opt.ProxyName, colorBlue) || !restarted.blueGreen.drainSince.Equal(mgr.blueGreen.drainSince) {
+		t.Fatalf("Expected blue to be draining since %s, got %+v", mgr.blueGreen.drainSince, restarted.blueGreen)
+	}
+	restarted.blueGreen.drainSince = time.Now().Add(-2 * time.Hour)
+	if err := restarted.checkBlueGreen(); err != nil {
+		t.Fatal(err)
+	}
+	if dep, _ := restarted.getDeployment(getColoredName(opt.ProxyName, colorBlue)); dep != nil {
+		t.Errorf("Expected drained blue Proxy to be deleted")
+	}
+}
+
+func TestBlueGreenMigration(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, source)
+	source.set(newPublicPort("a", "tcp", 5000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Proxy created by rolling updates is copied to blue before an update is prepared
+	opt.UpdateStrategy = UpdateStrategyBlueGreen
+	mgr = newTestManager(opt, k8sClient, source)
+	if err := mgr.generateCache(); err != nil {
+		t.Fatal(err)
+	}
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	blue, _ := mgr.getDeployment(getColoredName(opt.ProxyName, colorBlue))
+	green, _ := mgr.getDeployment(getColoredName(opt.ProxyName, colorGreen))
+	if blue == nil || green != nil {
+		t.Fatalf("Expected blue copy without green standby, got blue %v and green %v", blue != nil, green != nil)
+	}
+	if config, _ := getProxyConfig(blue); config != "tcp:5000=>amqp:a" {
+		t.Errorf("Expected blue to serve the current config, got %s", config)
+	}
+
+	// Once blue is selected the update is prepared on green
+	setReady(t, k8sClient, mgr, blue.Name)
+	if err := mgr.checkBlueGreen(); err != nil {
+		t.Fatal(err)
+	}
+	_, svc := getProxyObjects(t, mgr)
+	if svc.Spec.Selector[colorLabel] != colorBlue {
+		t.Errorf("Expected Service to select blue, got %v", svc.Spec.Selector)
+	}
+	green, _ = mgr.getDeployment(getColoredName(opt.ProxyName, colorGreen))
+	if green == nil {
+		t.Fatalf("Expected green standby with the update")
+	}
+	if config, _ := getProxyConfig(green); config != "tcp:5000=>amqp:a,tcp:5001=>amqp:b" {
+		t.Errorf("Expected green to hold the update, got %s", config)
+	}
+}
+
+func setReady(t *testing.T, k8sClient *fakeClient, mgr *Manager, name string) {
+	dep, err := mgr.getDeployment(name)
+	if err != nil || dep == nil {
+		t.Fatalf("Expected Deployment %s, got %v", name, err)
+	}
+	dep.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	if err := k8sClient.Update(context.TODO(), dep); err != nil {
+		t.Fatal(err)
+	}
+}
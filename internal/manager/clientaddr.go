@@ -0,0 +1,100 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+// Versions of the PROXY protocol
+const (
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+)
+
+// Proxy feature parsing the options of config items
+const ProxyFeatureClientAddress = "client-address"
+
+// Proxy config options are appended to a config item, e.g. tcp:5000=>amqp:queue;accept-proxy=v2;forward-client=v2
+const (
+	proxyOptionSeparator     = ";"
+	proxyOptionAcceptProxy   = "accept-proxy"
+	proxyOptionForwardClient = "forward-client"
+	forwardedForHeader       = "x-forwarded-for"
+)
+
+// ClientAddressRule preserves the client address on matching ports, the first matching rule applies
+// AcceptProxyProtocol expects a PROXY protocol header of that version from the load balancer
+// ForwardClientAddress passes the client address on to the microservice, in a PROXY header for TCP ports and in X-Forwarded-For for HTTP ports
+// The PROXY header sent to the microservice has the accepted version, v1 when none is accepted
+type ClientAddressRule struct {
+	PortSelector
+	AcceptProxyProtocol  string `json:"acceptProxyProtocol,omitempty"`
+	ForwardClientAddress bool   `json:"forwardClientAddress,omitempty"`
+}
+
+func getClientAddressRule(rules []ClientAddressRule, port ioclient.PublicPort) *ClientAddressRule {
+	for idx := range rules {
+		if rules[idx].matches(port) {
+			return &rules[idx]
+		}
+	}
+	return nil
+}
+
+func checkClientAddressRules(rules []ClientAddressRule) error {
+	for idx := range rules {
+		switch rules[idx].AcceptProxyProtocol {
+		case "", ProxyProtocolV1, ProxyProtocolV2:
+		default:
+			return fmt.Errorf("unsupported PROXY protocol version %s", rules[idx].AcceptProxyProtocol)
+		}
+		if rules[idx].Range != nil {
+			if err := rules[idx].Range.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeClientAddressOptions(config *strings.Builder, rule *ClientAddressRule, port ioclient.PublicPort) {
+	if rule == nil {
+		return
+	}
+	if rule.AcceptProxyProtocol != "" {
+		config.WriteString(proxyOptionSeparator + proxyOptionAcceptProxy + "=")
+		config.WriteString(rule.AcceptProxyProtocol)
+	}
+	if !rule.ForwardClientAddress {
+		return
+	}
+	config.WriteString(proxyOptionSeparator + proxyOptionForwardClient + "=")
+	switch {
+	case strings.HasPrefix(port.Protocol, "http"):
+		config.WriteString(forwardedForHeader)
+	case rule.AcceptProxyProtocol != "":
+		config.WriteString(rule.AcceptProxyProtocol)
+	default:
+		config.WriteString(ProxyProtocolV1)
+	}
+}
+
+// Proxy config of ports with the client address options of this proxy
+func (mgr *Manager) renderProxyConfig(ports portMap) string {
+	return createProxyConfig(ports, mgr.opt.ClientAddress)
+}
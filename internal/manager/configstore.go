@@ -0,0 +1,188 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Where the proxy config is stored
+const (
+	ConfigStorageArgs      = "args"      // Container argument, limited to maxArgSize
+	ConfigStorageConfigMap = "configmap" // ConfigMap mounted into the proxy, passed as a file reference
+)
+
+// Proxy feature reading the config from a file passed as @<path>
+const ProxyFeatureConfigFile = "config-file"
+
+const (
+	proxyConfigLabel      = "datasance.com/proxy-config"
+	proxyConfigVolumeName = "proxy-config"
+	proxyConfigMountPath  = "/etc/icproxy/config"
+	proxyConfigKey        = "proxy.conf"
+	proxyConfigFilePrefix = "@"
+	// Kernel limit of a single argument (MAX_ARG_STRLEN)
+	maxArgSize = 128 * 1024
+	// ConfigMap data limit, leaving room for metadata
+	maxConfigMapSize = 1000 * 1024
+)
+
+func (mgr *Manager) storesConfigInConfigMap() bool {
+	return mgr.opt.ProxyConfigStorage == ConfigStorageConfigMap
+}
+
+// ConfigMaps are immutable and named by their content so that each proxy template pins its config, which keeps rollbacks exact
+func getProxyConfigMapName(proxyName, config string) string {
+	sum := sha256.Sum256([]byte(config))
+	return proxyName + "-config-" + hex.EncodeToString(sum[:5])
+}
+
+// Reference the config ConfigMap from the proxy Deployment instead of passing the config as an argument
+func (mgr *Manager) setProxyConfigMap(dep *appsv1.Deployment, config string) {
+	spec := &dep.Spec.Template.Spec
+	mode := corev1.ConfigMapVolumeSourceDefaultMode
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: proxyConfigVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: getProxyConfigMapName(mgr.opt.ProxyName, config),
+				},
+				DefaultMode: &mode,
+			},
+		},
+	})
+	container := &spec.Containers[0]
+	container.Args = getProxyContainerArgs(proxyConfigFilePrefix + path.Join(proxyConfigMountPath, proxyConfigKey))
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      proxyConfigVolumeName,
+		MountPath: proxyConfigMountPath,
+		ReadOnly:  true,
+	})
+}
+
+// Ensure the proxy config can be delivered before a Deployment referencing it is written
+func (mgr *Manager) storeProxyConfig(config string) error {
+	if !mgr.storesConfigInConfigMap() {
+		if len(config) > maxArgSize {
+			return fmt.Errorf("proxy config of %d bytes exceeds the argument limit of %d bytes, use %s config storage",
+				len(config), maxArgSize, ConfigStorageConfigMap)
+		}
+		return nil
+	}
+	if len(config) > maxConfigMapSize {
+		return fmt.Errorf("proxy config of %d bytes exceeds the ConfigMap limit of %d bytes", len(config), maxConfigMapSize)
+	}
+
+	immutable := true
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getProxyConfigMapName(mgr.opt.ProxyName, config),
+			Namespace: mgr.opt.Namespace,
+			Labels: map[string]string{
+				"name":           mgr.opt.ProxyName,
+				proxyConfigLabel: "true",
+			},
+		},
+		Data: map[string]string{
+			proxyConfigKey: config,
+		},
+		Immutable: &immutable,
+	}
+	err := mgr.k8sClient.Get(context.TODO(), k8sclient.ObjectKeyFromObject(configMap), &corev1.ConfigMap{})
+	if !k8serrors.IsNotFound(err) {
+		return err
+	}
+	mgr.setOwnerReference(configMap)
+	return mgr.create(configMap)
+}
+
+// Read the config of a proxy Deployment from its argument or its ConfigMap
+func (mgr *Manager) readProxyConfig(dep *appsv1.Deployment) (string, error) {
+	config, err := getProxyConfig(dep)
+	if err != nil || !strings.HasPrefix(config, proxyConfigFilePrefix) {
+		return config, err
+	}
+	name := getProxyConfigVolume(&dep.Spec.Template)
+	if name == "" {
+		return "", fmt.Errorf("proxy Deployment %s references a config file without a config volume", dep.Name)
+	}
+	configMap := corev1.ConfigMap{}
+	key := k8sclient.ObjectKey{
+		Name:      name,
+		Namespace: dep.Namespace,
+	}
+	if err := mgr.k8sClient.Get(context.TODO(), key, &configMap); err != nil {
+		return "", err
+	}
+	return configMap.Data[proxyConfigKey], nil
+}
+
+// Name of the config ConfigMap referenced by a proxy template, empty when the config is an argument
+func getProxyConfigVolume(template *corev1.PodTemplateSpec) string {
+	for _, volume := range template.Spec.Volumes {
+		if volume.Name == proxyConfigVolumeName && volume.ConfigMap != nil {
+			return volume.ConfigMap.Name
+		}
+	}
+	return ""
+}
+
+// Delete config ConfigMaps no longer referenced by a proxy Deployment or the last known good template
+func (mgr *Manager) pruneProxyConfigs() error {
+	if !mgr.storesConfigInConfigMap() {
+		return nil
+	}
+	configMaps := corev1.ConfigMapList{}
+	if err := mgr.k8sClient.List(context.TODO(), &configMaps, k8sclient.InNamespace(mgr.opt.Namespace),
+		k8sclient.MatchingLabels{"name": mgr.opt.ProxyName, proxyConfigLabel: "true"}); err != nil {
+		return err
+	}
+	if len(configMaps.Items) == 0 {
+		return nil
+	}
+	deps := appsv1.DeploymentList{}
+	if err := mgr.k8sClient.List(context.TODO(), &deps, k8sclient.InNamespace(mgr.opt.Namespace),
+		k8sclient.MatchingLabels{"name": mgr.opt.ProxyName}); err != nil {
+		return err
+	}
+	referenced := make(map[string]bool)
+	for idx := range deps.Items {
+		referenced[getProxyConfigVolume(&deps.Items[idx].Spec.Template)] = true
+	}
+	if mgr.rollout.goodTemplate != nil {
+		referenced[getProxyConfigVolume(mgr.rollout.goodTemplate)] = true
+	}
+	for idx := range configMaps.Items {
+		configMap := &configMaps.Items[idx]
+		if referenced[configMap.Name] {
+			continue
+		}
+		if err := mgr.delete(configMap); err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+// Policies deciding which microservice keeps a public port claimed by several
+const (
+	ConflictPolicyFirstSeen = "first-seen" // Port stays with the microservice already exposed, else the first returned
+	ConflictPolicyOldest    = "oldest"     // Port goes to the microservice observed earliest by the manager, requires persisted state
+	ConflictPolicyReject    = "reject"     // Port is exposed for none of the claimants
+)
+
+// Microservices are described by name and UUID, the UUID alone when the name cannot be found
+func (mgr *Manager) describeClaims(claims []ioclient.MicroservicePublicPort) string {
+	descriptions := make([]string, 0, len(claims))
+	for idx := range claims {
+		uuid := claims[idx].MicroserviceUUID
+		if name, err := mgr.getMicroserviceName(uuid); err == nil && name != "" && name != uuid {
+			uuid = fmt.Sprintf("%s (%s)", name, uuid)
+		}
+		descriptions = append(descriptions, uuid)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+func (mgr *Manager) describeConflict(port int, winner *ioclient.MicroservicePublicPort, claims []ioclient.MicroservicePublicPort) string {
+	if winner == nil {
+		return fmt.Sprintf("Public port %d claimed by microservices %s, rejected all", port, mgr.describeClaims(claims))
+	}
+	losers := make([]ioclient.MicroservicePublicPort, 0, len(claims)-1)
+	for idx := range claims {
+		if claims[idx] != *winner {
+			losers = append(losers, claims[idx])
+		}
+	}
+	return fmt.Sprintf("Public port %d claimed by microservices %s, exposing %s and rejecting %s",
+		port, mgr.describeClaims(claims), mgr.describeClaims([]ioclient.MicroservicePublicPort{*winner}), mgr.describeClaims(losers))
+}
+
+// Ensure every public port is claimed by a single microservice, resolving conflicts according to the policy
+// Order of the returned ports follows the input
+func (mgr *Manager) resolveConflicts(ports []ioclient.MicroservicePublicPort) []ioclient.MicroservicePublicPort {
+	// Group distinct claims by port
+	claimsByPort := make(map[int][]ioclient.MicroservicePublicPort)
+	for _, port := range ports {
+		claims := claimsByPort[port.PublicPort.Port]
+		duplicate := false
+		for idx := range claims {
+			if claims[idx] == port {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			claimsByPort[port.PublicPort.Port] = append(claims, port)
+		}
+	}
+	mgr.trackMicroservices(ports)
+
+	// Resolve
+	conflicts := make(map[int]string)
+	resolved := make([]ioclient.MicroservicePublicPort, 0, len(ports))
+	handled := make(map[int]bool)
+	for _, port := range ports {
+		number := port.PublicPort.Port
+		if handled[number] {
+			continue
+		}
+		handled[number] = true
+		claims := claimsByPort[number]
+		if len(claims) == 1 {
+			resolved = append(resolved, claims[0])
+			continue
+		}
+		winner := mgr.pickClaim(claims)
+		conflicts[number] = mgr.describeConflict(number, winner, claims)
+		if winner != nil {
+			resolved = append(resolved, *winner)
+		}
+	}
+
+	mgr.reportConflicts(conflicts)
+	return resolved
+}
+
+func (mgr *Manager) pickClaim(claims []ioclient.MicroservicePublicPort) *ioclient.MicroservicePublicPort {
+	switch mgr.opt.ConflictPolicy {
+	case ConflictPolicyReject:
+		return nil
+	case ConflictPolicyOldest:
+		oldest := &claims[0]
+		for idx := 1; idx < len(claims); idx++ {
+			claim := &claims[idx]
+			claimSeen := mgr.firstSeen[claim.MicroserviceUUID]
+			oldestSeen := mgr.firstSeen[oldest.MicroserviceUUID]
+			if claimSeen.Before(oldestSeen) || (claimSeen.Equal(oldestSeen) && claim.MicroserviceUUID < oldest.MicroserviceUUID) {
+				oldest = claim
+			}
+		}
+		return oldest
+	default:
+		// Keep the port with the microservice currently exposed
+		if cached, exists := mgr.cache[claims[0].PublicPort.Port]; exists {
+			for idx := range claims {
+				if claims[idx].PublicPort == cached {
+					return &claims[idx]
+				}
+			}
+		}
+		return &claims[0]
+	}
+}
+
+// Remember when each microservice was first observed for the oldest policy
+// Observations are persisted with the state at the precision they are stored in so that restarts keep the order
+func (mgr *Manager) trackMicroservices(ports []ioclient.MicroservicePublicPort) {
+	now := time.Now().Truncate(time.Second)
+	current := make(map[string]bool)
+	for _, port := range ports {
+		current[port.MicroserviceUUID] = true
+		if _, exists := mgr.firstSeen[port.MicroserviceUUID]; !exists {
+			mgr.firstSeen[port.MicroserviceUUID] = now
+		}
+	}
+	for uuid := range mgr.firstSeen {
+		if !current[uuid] {
+			delete(mgr.firstSeen, uuid)
+		}
+	}
+}
+
+// Log every conflict, only record Events and count metrics when a conflict is new or changed
+func (mgr *Manager) reportConflicts(conflicts map[int]string) {
+	ports := make([]int, 0, len(conflicts))
+	for port := range conflicts {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		message := conflicts[port]
+		mgr.log.Info(message, "policy", mgr.opt.ConflictPolicy)
+		if mgr.conflicts[port] == message {
+			continue
+		}
+		metrics.portConflicts.Add(mgr.opt.ProxyName, 1)
+		mgr.recordEvent(corev1.EventTypeWarning, "PublicPortConflict", message)
+	}
+	for port := range mgr.conflicts {
+		if _, exists := conflicts[port]; !exists {
+			mgr.log.Info(fmt.Sprintf("Public port %d conflict resolved", port))
+		}
+	}
+	mgr.conflicts = conflicts
+	setGauge(metrics.activePortConflicts, mgr.opt.ProxyName, int64(len(conflicts)))
+}
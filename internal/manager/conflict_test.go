@@ -0,0 +1,113 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestReconcileConflicts(t *testing.T) {
+	for _, test := range []struct {
+		policy string
+		queue  string // Owner of port 6000, empty when rejected
+	}{
+		// a is returned first
+		{policy: ConflictPolicyFirstSeen, queue: "a"},
+		// b was observed a second before a
+		{policy: ConflictPolicyOldest, queue: "b"},
+		{policy: ConflictPolicyReject, queue: ""},
+	} {
+		t.Run(test.policy, func(t *testing.T) {
+			source := &fakePortSource{}
+			opt := newTestOptions()
+			opt.ConflictPolicy = test.policy
+			opt.PersistState = test.policy == ConflictPolicyOldest
+			k8sClient := newFakeClient()
+			mgr := newTestManager(opt, k8sClient, source)
+			mgr.microserviceNames = map[string]string{"a": "frontend", "b": "db"}
+
+			source.set(newPublicPort("b", "tcp", 6000))
+			mgr.trackMicroservices(source.ports)
+			mgr.firstSeen["b"] = mgr.firstSeen["b"].Add(-time.Second)
+			source.set(newPublicPort("a", "tcp", 5000), newPublicPort("a", "tcp", 6000), newPublicPort("b", "tcp", 6000))
+			if err := mgr.run(); err != nil {
+				t.Fatal(err)
+			}
+			if port, exists := mgr.cache[6000]; port.Queue != test.queue || exists != (test.queue != "") {
+				t.Errorf("Unexpected owner of port 6000: %v", mgr.cache)
+			}
+			events := getEvents(k8sClient, "PublicPortConflict")
+			if len(events) != 1 {
+				t.Fatalf("Expected one conflict Event, got %d", len(events))
+			}
+			if !strings.Contains(events[0].Message, "frontend (a)") || !strings.Contains(events[0].Message, "db (b)") {
+				t.Errorf("Expected microservices to be named, got %q", events[0].Message)
+			}
+
+			// Persisting conflict is not reported again
+			if err := mgr.run(); err != nil {
+				t.Fatal(err)
+			}
+			if len(getEvents(k8sClient, "PublicPortConflict")) != 1 {
+				t.Errorf("Expected conflict Event not to repeat")
+			}
+		})
+	}
+}
+
+// Observation order survives a restart of the manager
+func TestConflictOldestRestart(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.ConflictPolicy = ConflictPolicyOldest
+	opt.PersistState = true
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, source)
+
+	// z is observed long before b claims its port
+	source.set(newPublicPort("z", "tcp", 5000), newPublicPort("b", "tcp", 5001))
+	if err := mgr.reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	mgr.firstSeen["z"] = mgr.firstSeen["z"].Add(-time.Hour)
+	mgr.persistState()
+
+	restarted := newTestManager(newTestOptions(), k8sClient, source)
+	restarted.opt.ConflictPolicy = ConflictPolicyOldest
+	restarted.opt.PersistState = true
+	if err := restarted.start(); err != nil {
+		t.Fatal(err)
+	}
+	// Observations made after the restart alone would tie and favor b by UUID
+	source.set(newPublicPort("b", "tcp", 5001), newPublicPort("b", "tcp", 5000), newPublicPort("z", "tcp", 5000))
+	if err := restarted.run(); err != nil {
+		t.Fatal(err)
+	}
+	if restarted.cache[5000].Queue != "z" {
+		t.Errorf("Expected port to stay with the microservice observed first before the restart, got %v", restarted.cache)
+	}
+}
+
+func getEvents(k8sClient *fakeClient, reason string) (events []*corev1.Event) {
+	for _, obj := range k8sClient.objects {
+		if event, ok := obj.(*corev1.Event); ok && event.Reason == reason {
+			events = append(events, event)
+		}
+	}
+	return
+}
@@ -0,0 +1,87 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+const defaultProxyHostKey = "default-proxy-host"
+
+type controllerConfig struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Read a config value from the Controller, the SDK only supports writing them
+func (mgr *Manager) getControllerConfig(key string) (value string, found bool, err error) {
+	var body []byte
+	if err = mgr.callController(func(client *ioclient.Client) (err error) {
+		body, err = doControllerRequest(client, http.MethodGet, "/config", nil)
+		return
+	}); err != nil {
+		return
+	}
+	var configs []controllerConfig
+	if err = json.Unmarshal(body, &configs); err != nil {
+		return "", false, fmt.Errorf("could not decode Controller config: %s", err.Error())
+	}
+	for _, config := range configs {
+		if config.Key == key {
+			return config.Value, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func doControllerRequest(client *ioclient.Client, method, path string, payload interface{}) ([]byte, error) {
+	var reader io.Reader
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	url := strings.TrimSuffix(client.GetBaseURL(), "/") + path
+	request, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+client.GetAccessToken())
+
+	httpClient := http.Client{Transport: insecureTransport, Timeout: 10 * time.Second}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s returned %d: %s", method, path, response.StatusCode, string(body))
+	}
+	return body, nil
+}
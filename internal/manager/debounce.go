@@ -0,0 +1,80 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"time"
+)
+
+// Changes keep being batched at most this many settle windows after the first one
+const maxSettleWindows = 5
+
+// Batches cache changes so that a burst of new ports results in a single proxy rollout
+type rolloutPacer struct {
+	pending     bool
+	urgent      bool
+	firstChange time.Time
+	lastChange  time.Time
+	lastRollout time.Time
+}
+
+// Record a change of the cache or router, urgent changes bypass the settle window and minimum interval
+func (pacer *rolloutPacer) change(now time.Time, urgent bool) {
+	if !pacer.pending {
+		pacer.firstChange = now
+	}
+	pacer.pending = true
+	pacer.urgent = pacer.urgent || urgent
+	pacer.lastChange = now
+}
+
+// Whether pending changes should be rolled out now
+func (pacer *rolloutPacer) ready(now time.Time, settleWindow, minInterval time.Duration) bool {
+	if !pacer.pending {
+		return false
+	}
+	if pacer.urgent {
+		return true
+	}
+	if now.Sub(pacer.lastChange) < settleWindow && now.Sub(pacer.firstChange) < maxSettleWindows*settleWindow {
+		return false
+	}
+	return pacer.lastRollout.IsZero() || now.Sub(pacer.lastRollout) >= minInterval
+}
+
+func (pacer *rolloutPacer) rolledOut(now time.Time) {
+	pacer.pending = false
+	pacer.urgent = false
+	pacer.lastRollout = now
+}
+
+// Roll out pending changes once they have settled
+func (mgr *Manager) rolloutPending() error {
+	now := time.Now()
+	if !mgr.pacer.ready(now, mgr.opt.SettleWindow, mgr.opt.MinRolloutInterval) {
+		if mgr.pacer.pending {
+			mgr.log.Info("Holding back Proxy update until changes settle", "since", mgr.pacer.firstChange)
+			setGauge(metrics.pendingRollouts, mgr.opt.ProxyName, 1)
+		}
+		return nil
+	}
+	mgr.log.Info("Reconciled cache", "ports", len(mgr.cache))
+	if err := mgr.updateProxy(); err != nil {
+		return err
+	}
+	mgr.pacer.rolledOut(now)
+	setGauge(metrics.pendingRollouts, mgr.opt.ProxyName, 0)
+	metrics.proxyUpdates.Add(mgr.opt.ProxyName, 1)
+	return nil
+}
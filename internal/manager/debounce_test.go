@@ -0,0 +1,107 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+func TestReconcileSettleWindow(t *testing.T) {
+	testCases := []struct {
+		name     string
+		initial  []ioclient.MicroservicePublicPort   // Rolled out before the changes
+		changes  [][]ioclient.MicroservicePublicPort // Source response of each cycle
+		pending  int                                 // Ports served before the changes settle
+		expected int                                 // Ports served once they settled
+	}{
+		{
+			name: "ports arriving over several cycles are batched",
+			changes: [][]ioclient.MicroservicePublicPort{
+				{newPublicPort("a", "tcp", 5000)},
+				{newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001)},
+			},
+			expected: 2,
+		},
+		{
+			name:    "settled changes wait for the minimum rollout interval",
+			initial: []ioclient.MicroservicePublicPort{newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001)},
+			changes: [][]ioclient.MicroservicePublicPort{
+				{newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001), newPublicPort("c", "tcp", 5002)},
+			},
+			pending:  2,
+			expected: 2,
+		},
+		{
+			name:    "removals bypass both",
+			initial: []ioclient.MicroservicePublicPort{newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001)},
+			changes: [][]ioclient.MicroservicePublicPort{
+				{newPublicPort("a", "tcp", 5000)},
+			},
+			pending:  1,
+			expected: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := &fakePortSource{}
+			opt := newTestOptions()
+			opt.SettleWindow = time.Hour
+			opt.MinRolloutInterval = time.Hour
+			opt.UrgentRemovals = true
+			mgr := newTestManager(opt, newFakeClient(), source)
+			settle := func() {
+				mgr.pacer.firstChange = mgr.pacer.firstChange.Add(-2 * time.Hour)
+				mgr.pacer.lastChange = mgr.pacer.lastChange.Add(-2 * time.Hour)
+			}
+			run := func() {
+				t.Helper()
+				if err := mgr.run(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			getServed := func() int {
+				t.Helper()
+				dep, _ := getProxyObjects(t, mgr)
+				if dep == nil {
+					return 0
+				}
+				config, _ := getProxyConfig(dep)
+				return strings.Count(config, "tcp:")
+			}
+
+			if tc.initial != nil {
+				source.set(tc.initial...)
+				run()
+				settle()
+				run()
+			}
+			for _, ports := range tc.changes {
+				source.set(ports...)
+				run()
+			}
+			if served := getServed(); served != tc.pending {
+				t.Errorf("Expected %d ports before changes settle, got %d", tc.pending, served)
+			}
+			settle()
+			run()
+			if served := getServed(); served != tc.expected {
+				t.Errorf("Expected %d ports once changes settled, got %d", tc.expected, served)
+			}
+		})
+	}
+}
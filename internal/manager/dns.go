@@ -0,0 +1,282 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// How DNS names of the proxy are published to ExternalDNS
+const (
+	DNSProviderAnnotation = "annotation"  // Hostname annotation on the proxy Services
+	DNSProviderEndpoint   = "dnsendpoint" // DNSEndpoint resource of the ExternalDNS CRD source
+)
+
+const (
+	externalDNSHostnameAnnotation = "external-dns.alpha.kubernetes.io/hostname"
+	externalDNSTTLAnnotation      = "external-dns.alpha.kubernetes.io/ttl"
+)
+
+var dnsEndpointKind = schema.GroupVersionKind{
+	Group:   "externaldns.k8s.io",
+	Version: "v1alpha1",
+	Kind:    "DNSEndpoint",
+}
+
+func (mgr *Manager) isPublishingDNS() bool {
+	return mgr.opt.DNSProvider != ""
+}
+
+// Port names are assigned once the port source was read, until then published names are left as they are
+func (mgr *Manager) hasDNSNames() bool {
+	return mgr.opt.DNSHostnameTemplate == "" || len(mgr.dnsNames) != 0 || len(mgr.getServicePorts()) == 0
+}
+
+func checkDNSOptions(opt *Options) error {
+	switch opt.DNSProvider {
+	case "", DNSProviderAnnotation, DNSProviderEndpoint:
+	default:
+		return fmt.Errorf("unsupported DNS provider %s", opt.DNSProvider)
+	}
+	if opt.DNSProvider != "" && opt.DNSHostname == "" && opt.DNSHostnameTemplate == "" {
+		return fmt.Errorf("DNS provider %s requires a proxy hostname or a hostname template", opt.DNSProvider)
+	}
+	if opt.DNSRegisterHostname && opt.DNSHostname == "" {
+		return fmt.Errorf("registering the proxy hostname requires a proxy hostname")
+	}
+	return nil
+}
+
+// DNS name of each port from the hostname template, {microservice}, {application} and {port} are replaced
+func (mgr *Manager) assignDNSNames(ports []ioclient.MicroservicePublicPort) (changed bool, err error) {
+	if !mgr.isPublishingDNS() || mgr.opt.DNSHostnameTemplate == "" {
+		return false, nil
+	}
+	names := make(map[int]string)
+	for idx := range ports {
+		port := &ports[idx]
+		if mgr.isVirtualHosted(port.PublicPort) {
+			continue
+		}
+		name, err := mgr.getMicroserviceName(port.MicroserviceUUID)
+		if err != nil {
+			return false, err
+		}
+		application, err := mgr.getApplication(port.MicroserviceUUID)
+		if err != nil {
+			return false, err
+		}
+		replacer := strings.NewReplacer(
+			"{microservice}", sanitizeName(name),
+			"{application}", sanitizeName(application),
+			"{port}", strconv.Itoa(port.PublicPort.Port),
+		)
+		hostname := strings.ToLower(replacer.Replace(mgr.opt.DNSHostnameTemplate))
+		// Empty labels are left by microservices without application
+		hostname = strings.Trim(strings.ReplaceAll(hostname, "..", "."), ".")
+		names[port.PublicPort.Port] = hostname
+	}
+
+	// Ports kept in the cache, e.g. by the deletion guard, keep their name
+	for port, hostname := range mgr.dnsNames {
+		if _, exists := names[port]; !exists {
+			if _, cached := mgr.cache[port]; cached {
+				names[port] = hostname
+			}
+		}
+	}
+	changed = !equality.Semantic.DeepEqual(names, mgr.dnsNames)
+	mgr.dnsNames = names
+	return changed, nil
+}
+
+// Sorted DNS names of a Service, the proxy hostname is served by the first Service
+func (mgr *Manager) getDNSNames(ports portMap, proxyHost bool) []string {
+	unique := make(map[string]bool)
+	if proxyHost && mgr.opt.DNSHostname != "" {
+		unique[mgr.opt.DNSHostname] = true
+	}
+	for port := range ports {
+		if hostname, exists := mgr.dnsNames[port]; exists {
+			unique[hostname] = true
+		}
+	}
+	names := make([]string, 0, len(unique))
+	for hostname := range unique {
+		names = append(names, hostname)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Annotate a proxy Service with its DNS names for the ExternalDNS Service source
+func (mgr *Manager) setDNSAnnotations(svc *corev1.Service, ports portMap, proxyHost bool) {
+	if mgr.opt.DNSProvider != DNSProviderAnnotation || !mgr.hasDNSNames() {
+		return
+	}
+	annotations := make(map[string]string)
+	for key, value := range svc.Annotations {
+		annotations[key] = value
+	}
+	annotations[externalDNSHostnameAnnotation] = strings.Join(mgr.getDNSNames(ports, proxyHost), ",")
+	if mgr.opt.DNSTTL > 0 {
+		annotations[externalDNSTTLAnnotation] = strconv.Itoa(mgr.opt.DNSTTL)
+	}
+	svc.Annotations = annotations
+}
+
+// Ports of each proxy Service with its own address
+func (mgr *Manager) getAddressedServices() map[string]portMap {
+	services := make(map[string]portMap)
+	if mgr.opt.IsolatedServices {
+		for group, ports := range mgr.groupPorts() {
+			services[mgr.getGroupName(group)] = ports
+		}
+		return services
+	}
+	for shard, ports := range mgr.shardPorts {
+		if len(ports) != 0 {
+			services[mgr.getShardName(shard)] = ports
+		}
+	}
+	return services
+}
+
+// Desired DNSEndpoint, names are published once the Service serving them has an address
+func (mgr *Manager) newDNSEndpoint() (*unstructured.Unstructured, error) {
+	services := mgr.getAddressedServices()
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	targets := make(map[string]string)
+	for _, name := range names {
+		svc, err := mgr.getService(name)
+		if err != nil {
+			return nil, err
+		}
+		if svc == nil {
+			continue
+		}
+		addr, err := mgr.resolver.resolve(svc)
+		if err != nil {
+			return nil, err
+		}
+		if addr == "" {
+			continue
+		}
+		for _, hostname := range mgr.getDNSNames(services[name], name == mgr.opt.ProxyName) {
+			if _, exists := targets[hostname]; !exists {
+				targets[hostname] = addr
+			}
+		}
+	}
+	// Registered hostname must not point to itself
+	if mgr.opt.DNSRegisterHostname && targets[mgr.opt.DNSHostname] == mgr.opt.DNSHostname {
+		delete(targets, mgr.opt.DNSHostname)
+	}
+
+	hostnames := make([]string, 0, len(targets))
+	for hostname := range targets {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+	endpoints := make([]interface{}, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		addr := targets[hostname]
+		endpoint := map[string]interface{}{
+			"dnsName":    hostname,
+			"recordType": getRecordType(addr),
+			"targets":    []interface{}{addr},
+		}
+		if mgr.opt.DNSTTL > 0 {
+			endpoint["recordTTL"] = int64(mgr.opt.DNSTTL)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	endpoint := &unstructured.Unstructured{}
+	endpoint.SetGroupVersionKind(dnsEndpointKind)
+	endpoint.SetName(mgr.opt.ProxyName)
+	endpoint.SetNamespace(mgr.opt.Namespace)
+	endpoint.SetLabels(map[string]string{
+		"name": mgr.opt.ProxyName,
+	})
+	endpoint.Object["spec"] = map[string]interface{}{
+		"endpoints": endpoints,
+	}
+	return endpoint, nil
+}
+
+func getRecordType(addr string) string {
+	ip := net.ParseIP(addr)
+	switch {
+	case ip == nil:
+		return "CNAME"
+	case ip.To4() == nil:
+		return "AAAA"
+	}
+	return "A"
+}
+
+// Create, update or delete the DNSEndpoint, addresses are resolved each cycle as they may appear after the Services
+func (mgr *Manager) syncDNSEndpoint() error {
+	if mgr.opt.DNSProvider != DNSProviderEndpoint || !mgr.hasDNSNames() {
+		return nil
+	}
+	desired, err := mgr.newDNSEndpoint()
+	if err != nil {
+		return err
+	}
+	found := &unstructured.Unstructured{}
+	found.SetGroupVersionKind(dnsEndpointKind)
+	err = mgr.k8sClient.Get(context.TODO(), k8sclient.ObjectKeyFromObject(desired), found)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	endpoints, _, _ := unstructured.NestedSlice(desired.Object, "spec", "endpoints")
+	switch {
+	case len(endpoints) == 0:
+		if exists {
+			if err := mgr.delete(found); err != nil && !k8serrors.IsNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	case !exists:
+		mgr.setOwnerReference(desired)
+		return mgr.create(desired)
+	case equality.Semantic.DeepEqual(found.Object["spec"], desired.Object["spec"]):
+		return nil
+	}
+	found.Object["spec"] = desired.Object["spec"]
+	return mgr.update(found)
+}
@@ -0,0 +1,113 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+func TestReconcileDNSAnnotation(t *testing.T) {
+	source := &fakePortSource{}
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001), newPublicPort("b", "tcp", 5002))
+	opt := newTestOptions()
+	opt.DNSProvider = DNSProviderAnnotation
+	opt.DNSHostname = "proxy.example.com"
+	opt.DNSHostnameTemplate = "{microservice}.{application}.example.com"
+	mgr := newTestManager(opt, newFakeClient(), source)
+	mgr.applications = map[string]string{"a": "Shop", "b": "Shop"}
+	mgr.microserviceNames = map[string]string{"a": "web", "b": "db"}
+
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	_, svc := getProxyObjects(t, mgr)
+	if names := svc.Annotations[externalDNSHostnameAnnotation]; names != "db.shop.example.com,proxy.example.com,web.shop.example.com" {
+		t.Errorf("Expected DNS names on proxy Service, got %s", names)
+	}
+}
+
+// DNSEndpoint with the resolved address, the hostname is registered instead of the address
+func TestReconcileDNSEndpoint(t *testing.T) {
+	source := &fakePortSource{}
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001), newPublicPort("b", "tcp", 5002))
+	opt := newTestOptions()
+	opt.DNSHostname = "proxy.example.com"
+	opt.DNSHostnameTemplate = "{microservice}.{application}.example.com"
+	opt.DNSProvider = DNSProviderEndpoint
+	opt.DNSRegisterHostname = true
+	opt.AddressResolver = AddressResolverStatic
+	opt.ProxyExternalAddress = "203.0.113.10"
+	mgr := newTestManager(opt, newFakeClient(), source)
+	mgr.applications = map[string]string{"a": "Shop", "b": "Shop"}
+	mgr.microserviceNames = map[string]string{"a": "web", "b": "db"}
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.syncDNSEndpoint(); err != nil {
+		t.Fatal(err)
+	}
+	endpoint := &unstructured.Unstructured{}
+	endpoint.SetGroupVersionKind(dnsEndpointKind)
+	if err := mgr.k8sClient.Get(context.TODO(), k8sclient.ObjectKey{Name: opt.ProxyName, Namespace: opt.Namespace}, endpoint); err != nil {
+		t.Fatal(err)
+	}
+	endpoints, _, _ := unstructured.NestedSlice(endpoint.Object, "spec", "endpoints")
+	if len(endpoints) != 3 {
+		t.Fatalf("Expected 3 DNS endpoints, got %v", endpoints)
+	}
+	for _, item := range endpoints {
+		record := item.(map[string]interface{})
+		if record["recordType"] != "A" || fmt.Sprint(record["targets"]) != "[203.0.113.10]" {
+			t.Errorf("Expected A record of proxy address, got %v", record)
+		}
+	}
+	if addr, _ := mgr.resolveProxyAddress(); addr != "proxy.example.com" {
+		t.Errorf("Expected proxy hostname to be registered, got %s", addr)
+	}
+}
+
+func TestAssignDNSNames(t *testing.T) {
+	testCases := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{name: "microservice and application", template: "{microservice}.{application}.example.com", expected: "map[5000:web.web-shop.example.com 5001:frontend.example.com]"},
+		{name: "port", template: "{microservice}-{port}.example.com", expected: "map[5000:web-5000.example.com 5001:frontend-5001.example.com]"},
+		{name: "upper case template", template: "{microservice}.Example.com", expected: "map[5000:web.example.com 5001:frontend.example.com]"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opt := newTestOptions()
+			opt.DNSProvider = DNSProviderAnnotation
+			opt.DNSHostnameTemplate = tc.template
+			mgr := newTestManager(opt, newFakeClient(), &fakePortSource{})
+			mgr.applications = map[string]string{"a": "Web Shop", "b": ""}
+			mgr.microserviceNames = map[string]string{"a": "web", "b": "Frontend"}
+			if _, err := mgr.assignDNSNames([]ioclient.MicroservicePublicPort{newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001)}); err != nil {
+				t.Fatal(err)
+			}
+			if names := fmt.Sprint(mgr.dnsNames); names != tc.expected {
+				t.Errorf("Expected DNS names %s, got %s", tc.expected, names)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Record a Kubernetes Event against the port manager Deployment
+// Failures are only logged, Events are informational
+func (mgr *Manager) recordEvent(eventType, reason, message string) {
+	if mgr.opt.DryRun {
+		return
+	}
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: pkg.managerName + "-",
+			Namespace:    mgr.opt.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: mgr.owner.APIVersion,
+			Kind:       mgr.owner.Kind,
+			Name:       mgr.owner.Name,
+			UID:        mgr.owner.UID,
+			Namespace:  mgr.opt.Namespace,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: pkg.managerName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if err := mgr.k8sClient.Create(context.TODO(), event); err != nil {
+		mgr.log.Error(err, "Failed to record Event", "reason", reason)
+	}
+}
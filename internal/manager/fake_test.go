@@ -0,0 +1,276 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+// In-memory Kubernetes client covering the calls made by the manager
+// Unimplemented methods panic through the nil embedded interface
+type fakeClient struct {
+	k8sclient.Client
+	objects map[string]k8sclient.Object
+	writes  int
+	// Apply the defaults of the API server to stored objects
+	defaults bool
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{objects: make(map[string]k8sclient.Object)}
+}
+
+func fakeKey(obj k8sclient.Object, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", getKind(obj), namespace, name)
+}
+
+func (cl *fakeClient) Get(_ context.Context, key k8sclient.ObjectKey, obj k8sclient.Object, _ ...k8sclient.GetOption) error {
+	stored, exists := cl.objects[fakeKey(obj, key.Namespace, key.Name)]
+	if !exists {
+		return k8serrors.NewNotFound(schema.GroupResource{Resource: getKind(obj)}, key.Name)
+	}
+	reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(stored.DeepCopyObject()).Elem())
+	return nil
+}
+
+func (cl *fakeClient) Create(_ context.Context, obj k8sclient.Object, _ ...k8sclient.CreateOption) error {
+	if obj.GetName() == "" && obj.GetGenerateName() != "" {
+		obj.SetName(fmt.Sprintf("%s%d", obj.GetGenerateName(), len(cl.objects)))
+	}
+	key := fakeKey(obj, obj.GetNamespace(), obj.GetName())
+	if _, exists := cl.objects[key]; exists {
+		return k8serrors.NewAlreadyExists(schema.GroupResource{Resource: getKind(obj)}, obj.GetName())
+	}
+	cl.objects[key] = cl.store(obj)
+	cl.writes++
+	return nil
+}
+
+func (cl *fakeClient) Update(_ context.Context, obj k8sclient.Object, _ ...k8sclient.UpdateOption) error {
+	key := fakeKey(obj, obj.GetNamespace(), obj.GetName())
+	if _, exists := cl.objects[key]; !exists {
+		return k8serrors.NewNotFound(schema.GroupResource{Resource: getKind(obj)}, obj.GetName())
+	}
+	cl.objects[key] = cl.store(obj)
+	cl.writes++
+	return nil
+}
+
+func (cl *fakeClient) store(obj k8sclient.Object) k8sclient.Object {
+	stored := obj.DeepCopyObject().(k8sclient.Object)
+	if cl.defaults {
+		applyServerDefaults(stored)
+	}
+	return stored
+}
+
+// Subset of the API server defaults set on fields owned by the manager
+func applyServerDefaults(obj k8sclient.Object) {
+	switch obj := obj.(type) {
+	case *appsv1.Deployment:
+		if obj.Spec.RevisionHistoryLimit == nil {
+			limit := int32(10)
+			obj.Spec.RevisionHistoryLimit = &limit
+		}
+		if obj.Spec.Strategy.Type == "" {
+			obj.Spec.Strategy.Type = appsv1.RollingUpdateDeploymentStrategyType
+		}
+		podSpec := &obj.Spec.Template.Spec
+		if podSpec.RestartPolicy == "" {
+			podSpec.RestartPolicy = corev1.RestartPolicyAlways
+		}
+		if podSpec.DNSPolicy == "" {
+			podSpec.DNSPolicy = corev1.DNSClusterFirst
+		}
+		if podSpec.SchedulerName == "" {
+			podSpec.SchedulerName = corev1.DefaultSchedulerName
+		}
+		for idx := range podSpec.Volumes {
+			source := &podSpec.Volumes[idx].VolumeSource
+			mode := int32(0644)
+			if source.Secret != nil && source.Secret.DefaultMode == nil {
+				source.Secret.DefaultMode = &mode
+			}
+			if source.ConfigMap != nil && source.ConfigMap.DefaultMode == nil {
+				source.ConfigMap.DefaultMode = &mode
+			}
+		}
+		for idx := range podSpec.Containers {
+			container := &podSpec.Containers[idx]
+			if container.TerminationMessagePath == "" {
+				container.TerminationMessagePath = corev1.TerminationMessagePathDefault
+			}
+			if container.TerminationMessagePolicy == "" {
+				container.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+			}
+		}
+	case *corev1.Service:
+		if obj.Spec.SessionAffinity == "" {
+			obj.Spec.SessionAffinity = corev1.ServiceAffinityNone
+		}
+		for idx := range obj.Spec.Ports {
+			if obj.Spec.Ports[idx].Protocol == "" {
+				obj.Spec.Ports[idx].Protocol = corev1.ProtocolTCP
+			}
+		}
+	}
+}
+
+func (cl *fakeClient) Delete(_ context.Context, obj k8sclient.Object, _ ...k8sclient.DeleteOption) error {
+	key := fakeKey(obj, obj.GetNamespace(), obj.GetName())
+	if _, exists := cl.objects[key]; !exists {
+		return k8serrors.NewNotFound(schema.GroupResource{Resource: getKind(obj)}, obj.GetName())
+	}
+	delete(cl.objects, key)
+	cl.writes++
+	return nil
+}
+
+// Only label selectors are honored
+func (cl *fakeClient) List(_ context.Context, list k8sclient.ObjectList, opts ...k8sclient.ListOption) error {
+	listOpts := k8sclient.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	kind := strings.TrimSuffix(reflect.TypeOf(list).Elem().Name(), "List")
+	items := reflect.ValueOf(list).Elem().FieldByName("Items")
+	keys := make([]string, 0)
+	for key := range cl.objects {
+		if strings.HasPrefix(key, kind+"/") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		obj := cl.objects[key]
+		if listOpts.LabelSelector != nil && !listOpts.LabelSelector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		items.Set(reflect.Append(items, reflect.ValueOf(obj.DeepCopyObject()).Elem()))
+	}
+	return nil
+}
+
+func (cl *fakeClient) count(kind string) (count int) {
+	for key := range cl.objects {
+		if strings.HasPrefix(key, kind+"/") {
+			count++
+		}
+	}
+	return
+}
+
+// Public port source returning a fixed set of ports
+type fakePortSource struct {
+	ports []ioclient.MicroservicePublicPort
+	err   error
+}
+
+func (src *fakePortSource) GetPublicPorts() ([]ioclient.MicroservicePublicPort, error) {
+	return src.ports, src.err
+}
+
+func (src *fakePortSource) set(ports ...ioclient.MicroservicePublicPort) {
+	src.ports = ports
+}
+
+func newPublicPort(uuid, protocol string, port int) ioclient.MicroservicePublicPort {
+	return ioclient.MicroservicePublicPort{
+		MicroserviceUUID: uuid,
+		PublicPort: ioclient.PublicPort{
+			Protocol: protocol,
+			Queue:    uuid,
+			Port:     port,
+		},
+	}
+}
+
+func newTestOptions() *Options {
+	return &Options{
+		Namespace:        "default",
+		AuthURL:          "http://keycloak",
+		ProxyName:        "pot-proxy",
+		ProxyImage:       "proxy:latest",
+		ProxyServiceType: "LoadBalancer",
+		RouterAddress:    "router",
+	}
+}
+
+// Manager wired to fakes, address registrations are left in the work queue
+func newTestManager(opt *Options, k8sClient *fakeClient, source PublicPortSource) *Manager {
+	mgr := newManager(opt, logr.Discard())
+	mgr.k8sClient = k8sClient
+	mgr.source = source
+	return mgr
+}
+
+// Controller serving port mappings and recording the links written to them
+type fakeController struct {
+	sync.Mutex
+	mappings map[string]string
+	links    map[string]string
+	writes   int
+	failing  bool
+}
+
+func newFakeController(t *testing.T, mgr *Manager, mappings map[string]string) *fakeController {
+	ctrl := &fakeController{mappings: mappings, links: make(map[string]string)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctrl.Lock()
+		defer ctrl.Unlock()
+		path := strings.TrimPrefix(r.URL.Path, "/api/v3/microservices/")
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(path, "/port-mapping"):
+			fmt.Fprint(w, ctrl.mappings[strings.TrimSuffix(path, "/port-mapping")])
+		case r.Method == http.MethodPatch && ctrl.failing:
+			w.WriteHeader(http.StatusBadRequest)
+		case r.Method == http.MethodPatch:
+			body := ioclient.MicroservicePortMappingListResponse{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			ctrl.writes++
+			for _, mapping := range body.PortMappings {
+				if mapping.Public != nil && len(mapping.Public.Links) != 0 {
+					key := fmt.Sprintf("%s/%d", path, mapping.Internal)
+					ctrl.links[key] = strings.Join(mapping.Public.Schemes, ",") + " " + strings.Join(mapping.Public.Links, ",")
+				}
+			}
+		default:
+			fmt.Fprint(w, "{}")
+		}
+	}))
+	t.Cleanup(server.Close)
+	baseURL, _ := url.Parse(server.URL)
+	mgr.ioClient = ioclient.New(ioclient.Options{BaseURL: baseURL})
+	return ctrl
+}
@@ -0,0 +1,117 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Annotation on the proxy Deployment confirming a blocked mass removal, consumed when applied
+const confirmRemovalAnnotation = "datasance.com/confirm-port-removal"
+
+// State of a blocked mass removal
+type deletionGuard struct {
+	removal      string // Ports pending removal
+	observations int    // Consecutive cycles the same removal was observed
+}
+
+// Decide whether ports missing from the source may be removed from the cache
+func (mgr *Manager) checkRemovals(backendPortMap map[int]string) (bool, error) {
+	if mgr.opt.DeletionGuardPercent <= 0 || len(mgr.cache) == 0 {
+		return true, nil
+	}
+
+	removed := make([]int, 0)
+	for port := range mgr.cache {
+		if _, exists := backendPortMap[port]; !exists {
+			removed = append(removed, port)
+		}
+	}
+	if len(removed)*100 <= mgr.opt.DeletionGuardPercent*len(mgr.cache) {
+		mgr.guard = deletionGuard{}
+		return true, nil
+	}
+
+	// Guard tripped
+	sort.Ints(removed)
+	portStrings := make([]string, 0, len(removed))
+	for _, port := range removed {
+		portStrings = append(portStrings, strconv.Itoa(port))
+	}
+	removal := strings.Join(portStrings, ",")
+	if removal == mgr.guard.removal {
+		mgr.guard.observations++
+	} else {
+		mgr.guard = deletionGuard{removal: removal, observations: 1}
+		message := fmt.Sprintf("Refusing to remove %d of %d public ports (%s) without confirmation, annotate Deployment %s with %s=true to proceed",
+			len(removed), len(mgr.cache), removal, mgr.opt.ProxyName, confirmRemovalAnnotation)
+		metrics.deletionGuardTrips.Add(mgr.opt.ProxyName, 1)
+		mgr.recordEvent(corev1.EventTypeWarning, "MassPortRemovalBlocked", message)
+	}
+
+	if mgr.opt.DeletionGuardObservations > 0 && mgr.guard.observations >= mgr.opt.DeletionGuardObservations {
+		mgr.log.Info("Mass removal of public ports confirmed by consecutive observations", "ports", removal)
+		mgr.guard = deletionGuard{}
+		return true, nil
+	}
+	confirmed, err := mgr.consumeRemovalConfirmation()
+	if err != nil {
+		return false, err
+	}
+	if confirmed {
+		mgr.log.Info("Mass removal of public ports confirmed by annotation", "ports", removal)
+		mgr.guard = deletionGuard{}
+		return true, nil
+	}
+
+	mgr.log.Info("Mass removal of public ports blocked", "ports", removal, "observations", mgr.guard.observations)
+	return false, nil
+}
+
+// Check for the confirmation annotation on the proxy Deployment and remove it so it only applies once
+func (mgr *Manager) consumeRemovalConfirmation() (bool, error) {
+	proxyKey := k8sclient.ObjectKey{
+		Name:      mgr.getProxyDeploymentName(),
+		Namespace: mgr.opt.Namespace,
+	}
+	dep := appsv1.Deployment{}
+	if err := mgr.k8sClient.Get(context.TODO(), proxyKey, &dep); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if dep.Annotations[confirmRemovalAnnotation] != "true" {
+		return false, nil
+	}
+	// Annotation is left in place by a dry run, so the removal stays blocked as it was not confirmed by an applied cycle
+	if mgr.opt.DryRun {
+		mgr.log.Info("Dry run, mass removal of public ports would be confirmed by annotation")
+		return false, nil
+	}
+	delete(dep.Annotations, confirmRemovalAnnotation)
+	if err := mgr.update(&dep); err != nil {
+		return false, err
+	}
+	return true, nil
+}
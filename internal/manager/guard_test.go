@@ -0,0 +1,95 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+func TestReconcileDeletionGuard(t *testing.T) {
+	testCases := []struct {
+		name         string
+		observations int
+		annotate     bool
+		blockedRuns  int
+		remaining    []int
+	}{
+		{
+			name:         "confirmed by consecutive observations",
+			observations: 2,
+			blockedRuns:  1,
+			remaining:    []int{},
+		},
+		{
+			name:        "confirmed by annotation",
+			annotate:    true,
+			blockedRuns: 3,
+			remaining:   []int{5000},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := &fakePortSource{}
+			opt := newTestOptions()
+			opt.DeletionGuardPercent = 50
+			opt.DeletionGuardObservations = tc.observations
+			mgr := newTestManager(opt, newFakeClient(), source)
+
+			source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001), newPublicPort("c", "tcp", 5002))
+			if err := mgr.run(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Removal is blocked until confirmed, Proxy resources are kept
+			ports := make([]ioclient.MicroservicePublicPort, 0, len(tc.remaining))
+			for _, port := range tc.remaining {
+				ports = append(ports, newPublicPort("a", "tcp", port))
+			}
+			source.set(ports...)
+			for idx := 0; idx < tc.blockedRuns; idx++ {
+				if err := mgr.run(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(mgr.cache) != 3 {
+				t.Fatalf("Expected removal to be blocked, got cache %v", mgr.cache)
+			}
+			dep, svc := getProxyObjects(t, mgr)
+			if dep == nil || svc == nil {
+				t.Fatalf("Expected Proxy resources to be kept")
+			}
+
+			if tc.annotate {
+				dep.Annotations = map[string]string{confirmRemovalAnnotation: "true"}
+				if err := mgr.k8sClient.Update(context.TODO(), dep); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := mgr.run(); err != nil {
+				t.Fatal(err)
+			}
+			if len(mgr.cache) != len(tc.remaining) {
+				t.Errorf("Expected removal after confirmation, got cache %v", mgr.cache)
+			}
+			if tc.annotate {
+				if dep, _ = getProxyObjects(t, mgr); dep.Annotations[confirmRemovalAnnotation] != "" {
+					t.Errorf("Expected confirmation annotation to be consumed")
+				}
+			}
+		})
+	}
+}
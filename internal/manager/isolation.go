@@ -0,0 +1,263 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Isolation modes, each unit gets a dedicated proxy Deployment
+const (
+	IsolationPort        = "port"
+	IsolationApplication = "application"
+)
+
+const (
+	groupLabel          = "datasance.com/proxy-group"
+	maxResourceNameSize = 63
+)
+
+var invalidNameChars = regexp.MustCompile("[^a-z0-9-]+")
+
+func (mgr *Manager) isIsolated() bool {
+	return mgr.opt.Isolation != ""
+}
+
+// Group of a public port, deterministic so that resources keep their names across restarts
+func (mgr *Manager) getPortGroup(port int, microserviceUUID string) (string, error) {
+	if mgr.opt.Isolation == IsolationPort {
+		return strconv.Itoa(port), nil
+	}
+	application, err := mgr.getApplication(microserviceUUID)
+	if err != nil {
+		return "", err
+	}
+	// Groups are label values, at most 63 characters and never empty
+	group := sanitizeName(application)
+	if group == "" {
+		group = "application"
+	}
+	return group, nil
+}
+
+// Lowercase DNS label, invalid characters are replaced and long names shortened
+func sanitizeName(name string) string {
+	label := invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	return shortenName(strings.Trim(label, "-"), name, maxResourceNameSize)
+}
+
+// Name of the resources of a group, shortened with a hash of the group when too long
+func (mgr *Manager) getGroupName(group string) string {
+	return shortenName(mgr.opt.ProxyName+"-"+group, group, maxResourceNameSize)
+}
+
+// Name cut to size, ending in a hash of the key so that shortened names stay distinct
+func shortenName(name, key string, size int) string {
+	if len(name) <= size {
+		return name
+	}
+	sum := sha256.Sum256([]byte(key))
+	suffix := "-" + hex.EncodeToString(sum[:4])
+	return strings.TrimRight(name[:size-len(suffix)], "-") + suffix
+}
+
+// Named target port of the shared Service, only the pods of the group serving a port declare it
+func getTargetPortName(port int) string {
+	return "port-" + strconv.Itoa(port)
+}
+
+// Cached ports of each group
+func (mgr *Manager) groupPorts() map[string]portMap {
+	groups := make(map[string]portMap)
+	for port, publicPort := range mgr.cache {
+		group, known := mgr.portGroups[port]
+		if !known {
+			// Ports restored from persisted state are grouped once the source is read
+			if mgr.opt.Isolation != IsolationPort {
+				continue
+			}
+			group = strconv.Itoa(port)
+		}
+		if groups[group] == nil {
+			groups[group] = make(portMap)
+		}
+		groups[group][port] = publicPort
+	}
+	return groups
+}
+
+func (mgr *Manager) getGroupLabels(group string) map[string]string {
+	return map[string]string{
+		"name":     mgr.opt.ProxyName,
+		groupLabel: group,
+	}
+}
+
+// Desired proxy Deployment of a group, pods keep the proxy name label so that the NetworkPolicy and the shared Service cover all groups
+func (mgr *Manager) newGroupProxyDeployment(group, config string, ports portMap) *appsv1.Deployment {
+	dep := mgr.newProxyDeployment(config)
+	dep.Name = mgr.getGroupName(group)
+	labels := mgr.getGroupLabels(group)
+	dep.Labels = labels
+	dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+	dep.Spec.Template.Labels = labels
+	container := &dep.Spec.Template.Spec.Containers[0]
+	for _, port := range sortPorts(ports) {
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			Name:          getTargetPortName(port.Port),
+			ContainerPort: int32(port.Port),
+			Protocol:      corev1.ProtocolTCP,
+		})
+	}
+	return dep
+}
+
+// Desired Service of a group when each group is exposed separately
+func (mgr *Manager) newGroupProxyService(group string, ports portMap) *corev1.Service {
+	svc := newProxyService(mgr.opt.Namespace, mgr.getGroupName(group), ports, mgr.opt.ProxyServiceType, mgr.opt.ProxyServiceAnnotations, mgr.opt.ProxyExternalIPs, mgr.opt.PortMappings)
+	svc.Labels = mgr.getGroupLabels(group)
+	svc.Spec.Selector = mgr.getGroupLabels(group)
+	svc.Spec.LoadBalancerIP = mgr.opt.ProxyLoadBalancerIP
+	mgr.pinNodePorts(svc)
+	mgr.setDNSAnnotations(svc, ports, false)
+	return svc
+}
+
+// Route each port of the shared Service to the pods of its group
+func setTargetPortNames(svc *corev1.Service) {
+	for idx := range svc.Spec.Ports {
+		port := &svc.Spec.Ports[idx]
+		port.TargetPort = intstr.FromString(getTargetPortName(port.TargetPort.IntValue()))
+	}
+}
+
+// Read the cache from the Deployments of all groups
+func (mgr *Manager) generateIsolatedCache() (bool, error) {
+	deps := appsv1.DeploymentList{}
+	if err := mgr.k8sClient.List(context.TODO(), &deps, k8sclient.InNamespace(mgr.opt.Namespace), k8sclient.MatchingLabels{"name": mgr.opt.ProxyName}); err != nil {
+		return false, err
+	}
+	found := false
+	for idx := range deps.Items {
+		dep := &deps.Items[idx]
+		group, exists := dep.Labels[groupLabel]
+		if !exists {
+			continue
+		}
+		found = true
+		config, err := mgr.readProxyConfig(dep)
+		if err != nil {
+			return found, err
+		}
+		ports, err := decodeProxyConfig(config)
+		if err != nil {
+			return found, err
+		}
+		for _, port := range ports {
+			mgr.cache[port.Port] = port
+			mgr.portGroups[port.Port] = group
+		}
+	}
+	return found, nil
+}
+
+// Create, update and delete the Deployments and Services of all groups
+func (mgr *Manager) updateProxyIsolated() error {
+	groups := mgr.groupPorts()
+	names := make([]string, 0, len(groups))
+	for group := range groups {
+		names = append(names, group)
+	}
+	sort.Strings(names)
+
+	for _, group := range names {
+		ports := groups[group]
+		config := mgr.renderProxyConfig(ports)
+		if err := mgr.storeProxyConfig(config); err != nil {
+			return err
+		}
+		desired := mgr.newGroupProxyDeployment(group, config, ports)
+		found := appsv1.Deployment{}
+		err := mgr.k8sClient.Get(context.TODO(), k8sclient.ObjectKeyFromObject(desired), &found)
+		switch {
+		case k8serrors.IsNotFound(err):
+			mgr.setOwnerReference(desired)
+			err = mgr.create(desired)
+		case err == nil && reconcileProxyDeployment(&found, desired):
+			err = mgr.update(&found)
+		}
+		if err != nil {
+			return err
+		}
+
+		if !mgr.opt.IsolatedServices {
+			continue
+		}
+		desiredSvc := mgr.newGroupProxyService(group, ports)
+		foundSvc := corev1.Service{}
+		err = mgr.k8sClient.Get(context.TODO(), k8sclient.ObjectKeyFromObject(desiredSvc), &foundSvc)
+		switch {
+		case k8serrors.IsNotFound(err):
+			mgr.setOwnerReference(desiredSvc)
+			err = mgr.create(desiredSvc)
+		case err == nil && reconcileProxyService(&foundSvc, desiredSvc):
+			err = mgr.update(&foundSvc)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Remove groups without ports
+	deps := appsv1.DeploymentList{}
+	if err := mgr.k8sClient.List(context.TODO(), &deps, k8sclient.InNamespace(mgr.opt.Namespace), k8sclient.MatchingLabels{"name": mgr.opt.ProxyName}); err != nil {
+		return err
+	}
+	for idx := range deps.Items {
+		dep := &deps.Items[idx]
+		group, exists := dep.Labels[groupLabel]
+		// A proxy created before isolation was enabled is replaced by the groups
+		if (exists && groups[group] == nil) || (!exists && dep.Name == mgr.opt.ProxyName) {
+			if err := mgr.delete(dep); err != nil && !k8serrors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	svcs := corev1.ServiceList{}
+	if err := mgr.k8sClient.List(context.TODO(), &svcs, k8sclient.InNamespace(mgr.opt.Namespace), k8sclient.MatchingLabels{"name": mgr.opt.ProxyName}); err != nil {
+		return err
+	}
+	for idx := range svcs.Items {
+		svc := &svcs.Items[idx]
+		if group, exists := svc.Labels[groupLabel]; exists && (groups[group] == nil || !mgr.opt.IsolatedServices) {
+			if err := mgr.delete(svc); err != nil && !k8serrors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestReconcileIsolation(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.Isolation = IsolationPort
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, source)
+
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	for _, port := range []string{"5000", "5001"} {
+		dep, err := mgr.getDeployment(opt.ProxyName + "-" + port)
+		if err != nil || dep == nil {
+			t.Fatalf("Expected proxy Deployment for port %s", port)
+		}
+		if config, _ := getProxyConfig(dep); strings.Count(config, "tcp:") != 1 {
+			t.Errorf("Expected a single port in Deployment of port %s, got %s", port, config)
+		}
+	}
+	// Shared Service routes each port to the pods declaring its named target port
+	_, svc := getProxyObjects(t, mgr)
+	if svc == nil || svc.Spec.Selector["name"] != opt.ProxyName || len(svc.Spec.Ports) != 2 {
+		t.Fatalf("Expected shared Service selecting all groups, got %v", svc)
+	}
+	if target := svc.Spec.Ports[0].TargetPort; target.String() != "port-5000" {
+		t.Errorf("Expected named target port, got %s", target.String())
+	}
+	dep, _ := mgr.getDeployment(opt.ProxyName + "-5000")
+	if ports := dep.Spec.Template.Spec.Containers[0].Ports; len(ports) != 1 || ports[0].Name != "port-5000" {
+		t.Errorf("Expected proxy of port 5000 to declare its target port, got %v", ports)
+	}
+
+	// Restarted manager reads all groups, removed ports lose their resources
+	restarted := newTestManager(opt, k8sClient, source)
+	if err := restarted.generateCache(); err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.cache) != 2 || restarted.portGroups[5001] != "5001" {
+		t.Fatalf("Expected cache from group Deployments, got %v", restarted.cache)
+	}
+	source.set(newPublicPort("a", "tcp", 5000))
+	if err := restarted.run(); err != nil {
+		t.Fatal(err)
+	}
+	if dep, _ := restarted.getDeployment(opt.ProxyName + "-5001"); dep != nil {
+		t.Errorf("Expected Deployment of removed port to be deleted")
+	}
+}
+
+func TestReconcileIsolationLegacyProxy(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	k8sClient := newFakeClient()
+	source.set(newPublicPort("a", "tcp", 5000))
+	if err := newTestManager(opt, k8sClient, source).run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Proxy created before isolation was enabled is replaced
+	opt.Isolation = IsolationPort
+	mgr := newTestManager(opt, k8sClient, source)
+	if err := mgr.generateCache(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if dep, _ := mgr.getDeployment(opt.ProxyName); dep != nil {
+		t.Errorf("Expected Proxy created before isolation to be deleted")
+	}
+	if dep, _ := mgr.getDeployment(opt.ProxyName + "-5000"); dep == nil {
+		t.Errorf("Expected Proxy of port 5000")
+	}
+}
+
+func TestGetPortGroup(t *testing.T) {
+	long := strings.Repeat("application-", 10)
+	testCases := []struct {
+		application string
+		expected    string
+	}{
+		{application: "Shop_Front", expected: "shop-front"},
+		{application: "__", expected: "application"},
+		{application: long, expected: shortenName(strings.Trim(long, "-"), long, maxResourceNameSize)},
+	}
+	for _, tc := range testCases {
+		mgr := newTestManager(newTestOptions(), newFakeClient(), &fakePortSource{})
+		mgr.opt.Isolation = IsolationApplication
+		mgr.applications = map[string]string{"a": tc.application}
+		group, err := mgr.getPortGroup(5000, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if group != tc.expected || len(group) > maxResourceNameSize {
+			t.Errorf("Application %q: expected group %q, got %q", tc.application, tc.expected, group)
+		}
+	}
+}
+
+func TestReconcileIsolationApplication(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.Isolation = IsolationApplication
+	opt.IsolatedServices = true
+	opt.ProxyExternalAddress = "proxy.example.com"
+	opt.ProxyLoadBalancerIP = "203.0.113.10"
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, source)
+	mgr.applications = map[string]string{"a": "Shop_Front", "b": "Shop_Front", "c": "billing"}
+
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001), newPublicPort("c", "tcp", 5002))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	dep, _ := mgr.getDeployment(opt.ProxyName + "-shop-front")
+	if dep == nil {
+		t.Fatalf("Expected proxy Deployment per application")
+	}
+	if config, _ := getProxyConfig(dep); config != "tcp:5000=>amqp:a,tcp:5001=>amqp:b" {
+		t.Errorf("Expected both ports of the application, got %s", config)
+	}
+	if _, svc := getProxyObjects(t, mgr); svc != nil {
+		t.Errorf("Expected no shared Service with isolated Services")
+	}
+	svc := corev1.Service{}
+	if err := k8sClient.Get(context.TODO(), k8sclient.ObjectKey{Name: opt.ProxyName + "-billing", Namespace: opt.Namespace}, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Spec.Selector[groupLabel] != "billing" || len(svc.Spec.Ports) != 1 || svc.Spec.LoadBalancerIP != opt.ProxyLoadBalancerIP {
+		t.Errorf("Expected Service of billing to select its proxy, got %v", svc.Spec)
+	}
+}
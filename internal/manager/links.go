@@ -0,0 +1,265 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+package manager
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Externally reachable URL of a public port
+type portLink struct {
+	scheme string
+	url    string
+}
+
+// Link of each cached port, ports are left out until the Service serving them has an address
+func (mgr *Manager) getPortLinks() (map[int]portLink, error) {
+	links := make(map[int]portLink)
+
+	// Virtually hosted ports are reached through the Ingress on the default port of the scheme
+	if vhostPorts := mgr.getVirtualHostPorts(); len(vhostPorts) != 0 {
+		svc, err := mgr.getService(mgr.getVirtualHostName())
+		if err != nil {
+			return nil, err
+		}
+		scheme := "http"
+		if mgr.opt.HTTPTLSSecret != "" {
+			scheme = "https"
+		}
+		for number := range vhostPorts {
+			if hostname, exists := mgr.hostnames[number]; exists && svc != nil {
+				links[number] = portLink{scheme: scheme, url: scheme + "://" + hostname}
+			}
+		}
+	}
+
+	for name, ports := range mgr.getAddressedServices() {
+		svc, err := mgr.getService(name)
+		if err != nil {
+			return nil, err
+		}
+		var addr string
+		if name == mgr.opt.ProxyName {
+			addr, err = mgr.resolveProxyAddress()
+		} else if svc != nil {
+			addr, err = mgr.resolver.resolve(svc)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if addr == "" {
+			continue
+		}
+		for number, port := range ports {
+			external := getLinkPort(svc, mgr.opt.PortMappings, port)
+			if external == 0 {
+				continue
+			}
+			host := addr
+			if hostname, exists := mgr.dnsNames[number]; exists && mgr.isPublishingDNS() {
+				host = hostname
+			}
+			scheme := strings.ToLower(port.Protocol)
+			if scheme == "http2" {
+				scheme = "http"
+			}
+			links[number] = portLink{scheme: scheme, url: scheme + "://" + net.JoinHostPort(host, strconv.Itoa(external))}
+		}
+	}
+	return links, nil
+}
+
+// Port a public port is reached at, NodePort Services serve it on the node port allocated by the API Server
+// which is 0 until it is allocated
+func getLinkPort(svc *corev1.Service, mappings []PortMapping, port ioclient.PublicPort) int {
+	external := getExternalPort(mappings, port)
+	if svc == nil || svc.Spec.Type != corev1.ServiceTypeNodePort {
+		return external
+	}
+	for _, svcPort := range svc.Spec.Ports {
+		if int(svcPort.Port) == external {
+			return int(svcPort.NodePort)
+		}
+	}
+	return 0
+}
+
+// Queue changed links to be written to the microservices owning the ports, reported through Events as they change
+// Without PublishPortLinks only the links of remapped ports are written, so the Controller knows their external port
+func (mgr *Manager) publishPortLinks() error {
+	if !mgr.opt.PublishPortLinks && (len(mgr.opt.PortMappings) == 0 || !mgr.usesController()) {
+		return nil
+	}
+	for port := range mgr.publishedLinks {
+		if _, exists := mgr.cache[port]; !exists {
+			delete(mgr.publishedLinks, port)
+		}
+	}
+	for port := range mgr.pendingLinks {
+		if _, exists := mgr.cache[port]; !exists {
+			delete(mgr.pendingLinks, port)
+		}
+	}
+	links, err := mgr.getPortLinks()
+	if err != nil {
+		return err
+	}
+
+	ports := make([]int, 0, len(links))
+	for port := range links {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	queued := false
+	for _, port := range ports {
+		link := links[port]
+		owner, exists := mgr.portOwners[port]
+		// Ports written while remapped are kept up to date once their mapping is gone
+		published, written := mgr.publishedLinks[port]
+		remapped := getExternalPort(mgr.opt.PortMappings, mgr.cache[port]) != port
+		if !exists || (!mgr.opt.PublishPortLinks && !remapped && !written) || published == link.url {
+			delete(mgr.pendingLinks, port)
+			continue
+		}
+		if pending, exists := mgr.pendingLinks[port]; exists && pending == link {
+			continue
+		}
+		if mgr.opt.PublishPortLinks {
+			msg := fmt.Sprintf("Public port %d of microservice %s is reachable at %s", port, owner, link.url)
+			mgr.log.Info(msg)
+			mgr.recordEvent(corev1.EventTypeNormal, "PublicPortLink", msg)
+		}
+		mgr.pendingLinks[port] = link
+		queued = true
+	}
+	if !queued {
+		return nil
+	}
+	if mgr.opt.DryRun {
+		return mgr.writePortLinks()
+	}
+	mgr.queue.Add(linksItem)
+	return nil
+}
+
+// Write pending links to the microservices owning the ports
+// A failed microservice keeps its links pending for the retry, the others are still written
+func (mgr *Manager) writePortLinks() error {
+	changed := make(map[string][]int)
+	for port := range mgr.pendingLinks {
+		owner := mgr.portOwners[port]
+		changed[owner] = append(changed[owner], port)
+	}
+	owners := make([]string, 0, len(changed))
+	for owner := range changed {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	var firstErr error
+	for _, owner := range owners {
+		ports := changed[owner]
+		sort.Ints(ports)
+		if err := mgr.updatePortLinks(owner, ports); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("could not publish links of microservice %s: %s", owner, err.Error())
+		}
+	}
+	return firstErr
+}
+
+// Set the links and schemes of the public port mappings of a microservice
+func (mgr *Manager) updatePortLinks(uuid string, ports []int) error {
+	var mappings *ioclient.MicroservicePortMappingListResponse
+	if err := mgr.callController(func(client *ioclient.Client) (err error) {
+		mappings, err = client.GetMicroservicePortMapping(uuid)
+		return
+	}); err != nil {
+		return err
+	}
+
+	before := make([]string, 0, len(ports))
+	after := make([]string, 0, len(ports))
+	for _, port := range ports {
+		idx := findPortMapping(mappings.PortMappings, mgr.cache[port])
+		// Nothing to write until the port changes
+		if idx < 0 {
+			mgr.log.Info(fmt.Sprintf("Public port %d not found in port mappings of microservice %s", port, uuid))
+			continue
+		}
+		public := mappings.PortMappings[idx].Public
+		link := mgr.pendingLinks[port]
+		if len(public.Links) == 1 && public.Links[0] == link.url {
+			continue
+		}
+		before = append(before, strings.Join(public.Links, ","))
+		after = append(after, link.url)
+		public.Links = []string{link.url}
+		public.Schemes = []string{link.scheme}
+	}
+
+	if len(after) != 0 {
+		if mgr.opt.DryRun {
+			mgr.plan.record(Change{
+				Action: actionRegister,
+				Kind:   "MicroservicePortMapping",
+				Name:   uuid,
+				Diff:   diffLines(strings.Join(before, "\n"), strings.Join(after, "\n")),
+			})
+			return nil
+		}
+		if err := mgr.callController(func(client *ioclient.Client) error {
+			_, err := doControllerRequest(client, http.MethodPatch, "/microservices/"+uuid, mappings)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, port := range ports {
+		mgr.publishedLinks[port] = mgr.pendingLinks[port].url
+		delete(mgr.pendingLinks, port)
+	}
+	return nil
+}
+
+// Index of the public mapping of a port, matched by router port or as the only public mapping of its protocol
+func findPortMapping(mappings []ioclient.MicroservicePortMappingInfo, port ioclient.PublicPort) int {
+	candidates := make([]int, 0, 1)
+	for idx := range mappings {
+		public := mappings[idx].Public
+		if public == nil {
+			continue
+		}
+		if public.Router != nil {
+			if public.Router.Port == int64(port.Port) {
+				return idx
+			}
+			continue
+		}
+		if public.Protocol == "" || strings.EqualFold(public.Protocol, port.Protocol) {
+			candidates = append(candidates, idx)
+		}
+	}
+	if len(candidates) != 1 {
+		return -1
+	}
+	return candidates[0]
+}
@@ -0,0 +1,152 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPublishPortLinks(t *testing.T) {
+	testCases := []struct {
+		name     string
+		svcType  string
+		nodePort int32
+		expected []string
+	}{
+		{
+			name: "service ports",
+			expected: []string{
+				"Public port 5000 of microservice a is reachable at tcp://203.0.113.10:5000",
+				"Public port 5001 of microservice a is reachable at tcp://203.0.113.10:443",
+				"Public port 5002 of microservice b is reachable at http://203.0.113.10:5002",
+			},
+		},
+		{
+			name:     "allocated node ports",
+			svcType:  "NodePort",
+			nodePort: 31000,
+			expected: []string{
+				"Public port 5000 of microservice a is reachable at tcp://203.0.113.10:31000",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := &fakePortSource{}
+			source.set(newPublicPort("a", "tcp", 5000), newPublicPort("a", "tcp", 5001), newPublicPort("b", "http", 5002))
+			opt := newTestOptions()
+			opt.PublishPortLinks = true
+			opt.ProxyExternalAddress = "203.0.113.10"
+			opt.AddressResolver = AddressResolverStatic
+			if tc.svcType != "" {
+				opt.ProxyServiceType = tc.svcType
+			}
+			opt.PortMappings = []PortMapping{{PortSelector: PortSelector{Port: 5001}, ExternalPort: 443}}
+			k8sClient := newFakeClient()
+			mgr := newTestManager(opt, k8sClient, source)
+			if err := mgr.run(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Only the first port has a node port allocated
+			if tc.nodePort != 0 {
+				_, svc := getProxyObjects(t, mgr)
+				svc.Spec.Ports[0].NodePort = tc.nodePort
+				if err := k8sClient.Update(context.TODO(), svc); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := mgr.publishPortLinks(); err != nil {
+				t.Fatal(err)
+			}
+			getMessages := func() []string {
+				messages := make([]string, 0)
+				for _, event := range getEvents(k8sClient, "PublicPortLink") {
+					messages = append(messages, event.Message)
+				}
+				sort.Strings(messages)
+				return messages
+			}
+			if messages := getMessages(); fmt.Sprint(messages) != fmt.Sprint(tc.expected) {
+				t.Errorf("Expected links %v, got %v", tc.expected, messages)
+			}
+
+			// Unchanged links are not reported again
+			if err := mgr.publishPortLinks(); err != nil {
+				t.Fatal(err)
+			}
+			if events := getEvents(k8sClient, "PublicPortLink"); len(events) != len(tc.expected) {
+				t.Errorf("Expected no Events without changes, got %d", len(events)-len(tc.expected))
+			}
+		})
+	}
+}
+
+func TestWritePortLinks(t *testing.T) {
+	source := &fakePortSource{}
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("a", "tcp", 5001), newPublicPort("b", "http", 5002))
+	opt := newTestOptions()
+	opt.PublishPortLinks = true
+	opt.ProxyExternalAddress = "203.0.113.10"
+	opt.AddressResolver = AddressResolverStatic
+	opt.PortMappings = []PortMapping{{PortSelector: PortSelector{Port: 5001}, ExternalPort: 443}}
+	opt.RegistrationRetry = RetryPolicy{BaseDelay: time.Millisecond, MaxRetries: 1}
+	mgr := newTestManager(opt, newFakeClient(), source)
+	ctrl := newFakeController(t, mgr, map[string]string{
+		"a": `{"ports":[{"internal":80,"external":0,"public":{"protocol":"tcp","enabled":true,"router":{"host":"router","port":5000}}},` +
+			`{"internal":81,"external":0,"public":{"protocol":"tcp","enabled":true,"router":{"host":"router","port":5001}}}]}`,
+		"b": `{"ports":[{"internal":8080,"external":0,"public":{"protocol":"http","enabled":true}},{"internal":9090,"external":9090}]}`,
+	})
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	// Registration of the created Proxy address
+	mgr.processNextItem()
+
+	// A failed write stays pending and is retried through the queue
+	ctrl.failing = true
+	if err := mgr.publishPortLinks(); err != nil {
+		t.Fatal(err)
+	}
+	mgr.processNextItem()
+	if mgr.queue.NumRequeues(linksItem) != 1 || len(mgr.pendingLinks) != 3 || len(ctrl.links) != 0 {
+		t.Fatalf("Expected the failed write to be retried, got %d retries and %d pending links", mgr.queue.NumRequeues(linksItem), len(mgr.pendingLinks))
+	}
+
+	ctrl.failing = false
+	mgr.processNextItem()
+	expected := map[string]string{
+		"a/80":   "tcp tcp://203.0.113.10:5000",
+		"a/81":   "tcp tcp://203.0.113.10:443",
+		"b/8080": "http http://203.0.113.10:5002",
+	}
+	if fmt.Sprint(ctrl.links) != fmt.Sprint(expected) {
+		t.Errorf("Expected links %v, got %v", expected, ctrl.links)
+	}
+	if ctrl.writes != 2 || len(mgr.pendingLinks) != 0 || mgr.queue.NumRequeues(linksItem) != 0 {
+		t.Errorf("Expected a write per microservice, got %d writes and %d pending links", ctrl.writes, len(mgr.pendingLinks))
+	}
+
+	// Unchanged links are not written again
+	if err := mgr.publishPortLinks(); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.queue.Len() != 0 || len(mgr.pendingLinks) != 0 {
+		t.Errorf("Expected no writes without changes, got %d pending links", len(mgr.pendingLinks))
+	}
+}
@@ -29,10 +29,10 @@ import (
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/workqueue"
 	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
 
 	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
-	waitclient "github.com/datasance/iofog-go-sdk/v3/pkg/k8s"
 
 	"github.com/go-logr/logr"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -40,14 +40,46 @@ import (
 )
 
 type Manager struct {
-	opt         *Options
-	cache       portMap
-	k8sClient   k8sclient.Client
-	waitClient  *waitclient.Client
-	ioClient    *ioclient.Client
-	log         logr.Logger
-	owner       metav1.OwnerReference
-	addressChan chan string
+	opt               *Options
+	cache             portMap
+	k8sClient         k8sclient.Client
+	ioClient          *ioclient.Client
+	log               logr.Logger
+	owner             metav1.OwnerReference
+	queue             workqueue.TypedRateLimitingInterface[string]
+	breaker           circuitBreaker
+	pacer             rolloutPacer
+	rollout           rolloutTracker
+	blueGreen         blueGreenState
+	portGroups        map[int]string    // Group of each cached port in isolation mode
+	shards            map[int]int       // Service shard of each cached port
+	shardPorts        map[int]portMap   // Ports of each Service shard, assigned once per cycle
+	shardAddresses    map[string]string // Last reported address of each additional shard Service
+	externalPorts     map[int]int       // External port of each cached port exposed on another number
+	hostnames         map[int]string    // Hostname of each virtually hosted port
+	dnsNames          map[int]string    // DNS name of each port published to ExternalDNS
+	portOwners        map[int]string    // Microservice of each port read from the source
+	publishedLinks    map[int]string    // Public URL of each port written to the Controller
+	pendingLinks      map[int]portLink  // Links waiting in the work queue to be written to the Controller
+	router            routerInfo
+	plan              *Plan
+	source            PublicPortSource
+	conflicts         map[int]string       // Messages of unresolved public port conflicts
+	firstSeen         map[string]time.Time // First observation of each microservice
+	rejections        map[string]string    // Messages of ports rejected by the port policy
+	applications      map[string]string    // Application of each microservice
+	microserviceNames map[string]string    // Name of each microservice
+	guard             deletionGuard
+	state             managerState
+	resolver          addressResolver
+	// Address last handed to the registration routine by address tracking
+	requestedAddress string
+	// Address waiting in the work queue for registration, empty to resolve it from the Service
+	pendingAddress string
+	// Drift of the Controller value last reported through an Event, empty while it matches the address
+	reportedDrift string
+	// Last failure reading the router details by Event reason
+	routerErrors map[string]string
 }
 
 type Options struct {
@@ -63,13 +95,91 @@ type Options struct {
 	ProxyServiceAnnotations map[string]string
 	RouterServerName        string
 	RouterTransport         string
+	RouterTLSSecret         string // Secret with ca.crt, tls.crt and tls.key mounted into the proxy
 	ProtocolFilter          string
 	ProxyExternalAddress    string
-	RouterAddress           string
-	ControllerScheme        string
+	ProxyExternalIPs        []string // Assigned to the proxy Service
+	ProxyLoadBalancerIP     string   // Requested for every proxy Service, so that several Services share one address
+	// Strategy resolving the address registered with the Controller, defaults based on ProxyExternalAddress and ProxyServiceType
+	AddressResolver       string
+	AddressPreferHostname bool              // Prefer the LoadBalancer hostname over its IP
+	NodeAddressType       string            // Node address used by the nodeport strategy, defaults to ExternalIP
+	NodeSelector          map[string]string // Nodes considered by the nodeport strategy
+	NodePortRange         PortRange         // Node port range of the cluster, defaults to 30000-32767
+	RouterAddress         string
+	RouterDiscovery       bool // Resolve the router from the Controller instead of RouterAddress
+	ControllerScheme      string
+	DryRun                bool   // Record changes in a Plan instead of applying them
+	PortSource            string // One of controller, file or crd
+	PortSourceFile        string
+	ConflictPolicy        string // One of first-seen, oldest or reject
+	PortPolicy            PortPolicy
+	// Removing more than DeletionGuardPercent of ports in a cycle requires confirmation, 0 disables the guard
+	// Confirmation is given by the same removal being observed DeletionGuardObservations times in a row or by annotating the proxy Deployment
+	DeletionGuardPercent      int
+	DeletionGuardObservations int
+	// Persist last known state to a ConfigMap, reported stale when the port source has not been read for StateStaleAfter
+	PersistState    bool
+	StateStaleAfter time.Duration
+	// NetworkPolicy restricting proxy pods to public ports and the router
+	ProxyNetworkPolicy             bool
+	NetworkPolicyCIDRs             []string
+	NetworkPolicyNamespaceSelector map[string]string
+	// Cache changes are rolled out once no further change was seen for SettleWindow
+	// and at least MinRolloutInterval after the previous rollout, removals bypass both when UrgentRemovals is set
+	SettleWindow       time.Duration
+	MinRolloutInterval time.Duration
+	UrgentRemovals     bool
+	// Proxy rollouts not ready within RolloutDeadline are rolled back, 0 defaults to 5 minutes and a negative value disables tracking
+	RolloutDeadline time.Duration
+	// One of rolling or bluegreen, blue/green keeps the previous proxy for DrainPeriod after switching traffic
+	UpdateStrategy string
+	DrainPeriod    time.Duration
+	// Dedicated proxy Deployment per port or application, exposed through the shared Service unless IsolatedServices is set
+	Isolation        string
+	IsolatedServices bool
+	// One of args or configmap, a ConfigMap is required beyond a few thousand ports
+	ProxyConfigStorage string
+	// Capabilities of ProxyImage, options the proxy cannot parse are refused
+	ProxyFeatures []string
+	// Ports are spread over additional Services beyond MaxServicePorts per Service, 0 keeps all ports on one Service
+	MaxServicePorts int
+	// Rules exposing public ports on other external port numbers
+	PortMappings []PortMapping
+	// HTTP ports are served through an Ingress at hostnames under HTTPBaseDomain instead of a port each on the proxy Service
+	HTTPBaseDomain   string
+	HTTPIngressClass string
+	HTTPTLSSecret    string
+
+	// Namespaces of the Ingress controller, let through the proxy NetworkPolicy to virtually hosted ports
+	HTTPIngressNamespaceSelector map[string]string
+	// Rules accepting PROXY protocol from the load balancer and forwarding client addresses to microservices
+	ClientAddress []ClientAddressRule
+	// DNS names published through ExternalDNS by annotation or dnsendpoint, DNSHostname names the proxy itself
+	// and DNSHostnameTemplate names each port, DNSRegisterHostname registers DNSHostname with the Controller instead of the address
+	DNSProvider         string
+	DNSHostname         string
+	DNSHostnameTemplate string
+	DNSRegisterHostname bool
+	DNSTTL              int
+	// Write the public URL of each port back to its microservice once the Service serving it has an address
+	PublishPortLinks bool
+	// Backoff of failed reconcile cycles, address registrations and port link writes
+	ReconcileRetry    RetryPolicy
+	RegistrationRetry RetryPolicy
+	// Retries of each Controller API request within the SDK, 0 defaults to 10 and a negative value disables them
+	ControllerRetries int
+	// Consecutive Controller API failures before calls are paused for CircuitBreakerCooldown, negative disables the breaker
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
 	Config                  *rest.Config
 }
 
+// Keycloak and Controller certificates are not verified
+var insecureTransport = &http.Transport{
+	TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+}
+
 func (mgr *Manager) loginIofogClient(ioClient *ioclient.Client) error {
 	authURL := mgr.opt.AuthURL
 	realm := mgr.opt.Realm
@@ -86,10 +196,7 @@ func (mgr *Manager) loginIofogClient(ioClient *ioclient.Client) error {
 	payload := fmt.Sprintf("grant_type=client_credentials&client_id=%s&client_secret=%s", clientID, clientSecret)
 
 	// Create HTTP client with custom transport to skip certificate verification
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	client := &http.Client{Transport: tr}
+	client := &http.Client{Transport: insecureTransport}
 
 	// Create request
 	req, err := http.NewRequest(method, tokenURL, strings.NewReader(payload))
@@ -127,13 +234,7 @@ func (mgr *Manager) loginIofogClient(ioClient *ioclient.Client) error {
 func New(opt *Options) (*Manager, error) {
 	logf.SetLogger(zap.New())
 
-	mgr := &Manager{
-		cache:       make(portMap),
-		log:         logf.Log.WithName(opt.ProxyName),
-		opt:         opt,
-		addressChan: make(chan string, 5),
-	}
-	mgr.opt.ProtocolFilter = strings.ToUpper(mgr.opt.ProtocolFilter)
+	mgr := newManager(opt, logf.Log.WithName(opt.ProxyName))
 	if err := mgr.init(); err != nil {
 		return nil, err
 	}
@@ -141,6 +242,92 @@ func New(opt *Options) (*Manager, error) {
 	return mgr, nil
 }
 
+// Instantiate a Manager without connecting to any API
+func newManager(opt *Options, log logr.Logger) *Manager {
+	mgr := &Manager{
+		cache:             make(portMap),
+		log:               log,
+		opt:               opt,
+		router:            newStaticRouterInfo(opt),
+		conflicts:         make(map[int]string),
+		firstSeen:         make(map[string]time.Time),
+		rejections:        make(map[string]string),
+		applications:      make(map[string]string),
+		microserviceNames: make(map[string]string),
+		portGroups:        make(map[int]string),
+		shards:            make(map[int]int),
+		shardAddresses:    make(map[string]string),
+		externalPorts:     make(map[int]int),
+		hostnames:         make(map[int]string),
+		dnsNames:          make(map[int]string),
+		portOwners:        make(map[int]string),
+		publishedLinks:    make(map[int]string),
+		pendingLinks:      make(map[int]portLink),
+		routerErrors:      make(map[string]string),
+	}
+	if opt.DryRun {
+		mgr.plan = newPlan(opt.ProxyName)
+	}
+	mgr.opt.ProtocolFilter = strings.ToUpper(mgr.opt.ProtocolFilter)
+	if mgr.opt.ConflictPolicy == "" {
+		mgr.opt.ConflictPolicy = ConflictPolicyFirstSeen
+	}
+	if mgr.opt.PortSource == "" {
+		mgr.opt.PortSource = PortSourceController
+	}
+	if mgr.opt.StateStaleAfter == 0 {
+		mgr.opt.StateStaleAfter = 5 * time.Minute
+	}
+	if mgr.opt.AddressResolver == "" {
+		mgr.opt.AddressResolver = getDefaultAddressResolver(mgr.opt)
+	}
+	// Service type follows the strategy unless configured, checkOptions refuses a mismatch
+	if mgr.opt.ProxyServiceType == "" {
+		mgr.opt.ProxyServiceType = string(corev1.ServiceTypeLoadBalancer)
+		if svcType := getResolverServiceType(mgr.opt.AddressResolver); svcType != "" {
+			mgr.opt.ProxyServiceType = string(svcType)
+		}
+	}
+	if mgr.opt.NodePortRange == (PortRange{}) {
+		mgr.opt.NodePortRange = PortRange{Start: 30000, End: 32767}
+	}
+	mgr.resolver = newAddressResolver(mgr)
+	mgr.opt.ReconcileRetry = withRetryDefaults(mgr.opt.ReconcileRetry)
+	mgr.opt.RegistrationRetry = withRetryDefaults(mgr.opt.RegistrationRetry)
+	if mgr.opt.ControllerRetries == 0 {
+		mgr.opt.ControllerRetries = 10
+	}
+	if mgr.opt.CircuitBreakerThreshold == 0 {
+		mgr.opt.CircuitBreakerThreshold = 5
+	}
+	if mgr.opt.UpdateStrategy == "" {
+		mgr.opt.UpdateStrategy = UpdateStrategyRolling
+	}
+	if mgr.opt.ProxyConfigStorage == "" {
+		mgr.opt.ProxyConfigStorage = ConfigStorageArgs
+	}
+	if mgr.opt.DrainPeriod == 0 {
+		mgr.opt.DrainPeriod = 5 * time.Minute
+	}
+	if mgr.opt.RolloutDeadline == 0 {
+		mgr.opt.RolloutDeadline = 5 * time.Minute
+	}
+	if mgr.opt.CircuitBreakerCooldown == 0 {
+		mgr.opt.CircuitBreakerCooldown = time.Minute
+	}
+	mgr.breaker = circuitBreaker{
+		threshold: mgr.opt.CircuitBreakerThreshold,
+		cooldown:  mgr.opt.CircuitBreakerCooldown,
+	}
+	mgr.queue = workqueue.NewTypedRateLimitingQueue[string](newJitterRateLimiter(map[string]RetryPolicy{
+		reconcileItem: mgr.opt.ReconcileRetry,
+		registerItem:  mgr.opt.RegistrationRetry,
+		linksItem:     mgr.opt.RegistrationRetry,
+	}))
+	mgr.state.started = time.Now()
+	return mgr
+}
+
 // Query the K8s API Server for details of this pod's deployment
 // Store details for later use when assigning owners to other K8s resources we make
 // Owner reference is required for automatic cleanup of K8s resources made by this runtime
@@ -162,15 +349,107 @@ func (mgr *Manager) getOwnerReference() error {
 	return nil
 }
 
+// Refuse unsupported and conflicting options
+func (mgr *Manager) checkOptions() error {
+	switch mgr.opt.ConflictPolicy {
+	case ConflictPolicyFirstSeen, ConflictPolicyOldest, ConflictPolicyReject:
+	default:
+		return fmt.Errorf("unsupported conflict policy %s", mgr.opt.ConflictPolicy)
+	}
+	if mgr.resolver == nil {
+		return fmt.Errorf("unsupported address resolver %s", mgr.opt.AddressResolver)
+	}
+	if svcType := getResolverServiceType(mgr.opt.AddressResolver); svcType != "" && string(svcType) != mgr.opt.ProxyServiceType {
+		return fmt.Errorf("address resolver %s requires Service type %s, got %s", mgr.opt.AddressResolver, svcType, mgr.opt.ProxyServiceType)
+	}
+	if err := mgr.opt.NodePortRange.validate(); err != nil {
+		return fmt.Errorf("invalid node port range: %s", err.Error())
+	}
+	switch mgr.opt.UpdateStrategy {
+	case UpdateStrategyRolling, UpdateStrategyBlueGreen:
+	default:
+		return fmt.Errorf("unsupported update strategy %s", mgr.opt.UpdateStrategy)
+	}
+	switch mgr.opt.Isolation {
+	case "", IsolationPort, IsolationApplication:
+	default:
+		return fmt.Errorf("unsupported isolation mode %s", mgr.opt.Isolation)
+	}
+	switch mgr.opt.ProxyConfigStorage {
+	case ConfigStorageArgs, ConfigStorageConfigMap:
+	default:
+		return fmt.Errorf("unsupported proxy config storage %s", mgr.opt.ProxyConfigStorage)
+	}
+	if mgr.storesConfigInConfigMap() && !mgr.hasProxyFeature(ProxyFeatureConfigFile) {
+		return fmt.Errorf("proxy config storage %s requires a proxy image with the %s feature", ConfigStorageConfigMap, ProxyFeatureConfigFile)
+	}
+	if err := checkPortPolicy(&mgr.opt.PortPolicy); err != nil {
+		return err
+	}
+	if err := checkPortMappings(mgr.opt.PortMappings); err != nil {
+		return err
+	}
+	if err := checkClientAddressRules(mgr.opt.ClientAddress); err != nil {
+		return err
+	}
+	if len(mgr.opt.ClientAddress) != 0 && !mgr.hasProxyFeature(ProxyFeatureClientAddress) {
+		return fmt.Errorf("client address rules require a proxy image with the %s feature", ProxyFeatureClientAddress)
+	}
+	if err := checkDNSOptions(mgr.opt); err != nil {
+		return err
+	}
+	if err := mgr.checkIngressController(); err != nil {
+		return err
+	}
+	if mgr.isIsolated() && mgr.isVirtualHosting() {
+		return errors.New("isolation mode does not support virtual hosting")
+	}
+	if mgr.isIsolated() && mgr.isSharded() {
+		return errors.New("isolation mode does not support Service sharding")
+	}
+	if mgr.isIsolated() && mgr.isBlueGreen() {
+		return errors.New("isolation mode does not support blue/green updates")
+	}
+	// Observation order of microservices must survive restarts
+	if mgr.opt.ConflictPolicy == ConflictPolicyOldest && !mgr.opt.PersistState {
+		return errors.New("oldest conflict policy requires persisted state")
+	}
+	if mgr.opt.IsolatedServices {
+		if err := mgr.checkSharedAddress("isolated Services"); err != nil {
+			return err
+		}
+	}
+	if mgr.isSharded() {
+		if err := mgr.checkSharedAddress("Service shards"); err != nil {
+			return err
+		}
+	}
+
+	// File and CRD sources may run without a Controller, the proxy address is then not registered
+	if mgr.opt.PortSource == PortSourceController && !mgr.usesController() {
+		return errors.New("controller port source requires Controller credentials")
+	}
+	if !mgr.usesController() && (mgr.opt.RouterDiscovery || mgr.opt.PublishPortLinks) {
+		return errors.New("router discovery and public port links require Controller credentials")
+	}
+	return nil
+}
+
 func (mgr *Manager) init() (err error) {
+	if err := mgr.checkOptions(); err != nil {
+		return err
+	}
+
 	// Instantiate Kubernetes client
 	if mgr.k8sClient, err = k8sclient.New(mgr.opt.Config, k8sclient.Options{}); err != nil {
 		return
 	}
-	if mgr.waitClient, err = waitclient.NewInCluster(); err != nil {
+	mgr.log.Info("Created Kubernetes clients")
+
+	// Set up public port source
+	if mgr.source, err = newPortSource(mgr); err != nil {
 		return
 	}
-	mgr.log.Info("Created Kubernetes clients")
 
 	// Get owner reference
 	if err = mgr.getOwnerReference(); err != nil {
@@ -179,35 +458,12 @@ func (mgr *Manager) init() (err error) {
 	mgr.log.Info("Got owner reference from Kubernetes API Server")
 
 	// Set up ioFog client
-	ioclient.SetGlobalRetries(ioclient.Retries{
-		CustomMessage: map[string]int{
-			"timeout":    10,
-			"refuse":     10,
-			"credential": 10,
-		},
-	})
-
-	baseURLStr := fmt.Sprintf("%v://%s.%s:%d/api/v3", mgr.opt.ControllerScheme, pkg.controllerServiceName, mgr.opt.Namespace, pkg.controllerPort)
-	baseURL, err := url.Parse(baseURLStr)
-	if err != nil {
-		return fmt.Errorf("could not parse Controller URL %s: %s", baseURLStr, err.Error())
-	}
-
-	ioClient := ioclient.New(ioclient.Options{
-		BaseURL: baseURL,
-		Timeout: 1,
-	})
-
-	// Generate Controller Access Token
-	if err := mgr.loginIofogClient(ioClient); err != nil {
-		mgr.log.Error(err, "Failed to generate Access Token")
+	if mgr.usesController() {
+		if err := mgr.connectController(); err != nil {
+			mgr.log.Error(err, "Failed to log into Controller API")
+		}
 	}
 
-	mgr.log.Info("Logged into Controller API")
-
-	// Start address register routine
-	go mgr.registerProxyAddress()
-
 	// Check if Proxy Service exists
 	svc := corev1.Service{}
 	proxyKey := k8sclient.ObjectKey{
@@ -223,7 +479,50 @@ func (mgr *Manager) init() (err error) {
 		return err
 	}
 	// Service exists, register Service IP
-	mgr.addressChan <- mgr.opt.ProxyExternalAddress
+	mgr.registerAddress(mgr.opt.ProxyExternalAddress)
+	return nil
+}
+
+// Whether Controller credentials are configured
+func (mgr *Manager) usesController() bool {
+	return mgr.opt.AuthURL != ""
+}
+
+// Whether the proxy image was declared to support a feature
+func (mgr *Manager) hasProxyFeature(feature string) bool {
+	for _, supported := range mgr.opt.ProxyFeatures {
+		if strings.EqualFold(supported, feature) {
+			return true
+		}
+	}
+	return false
+}
+
+// Create a Controller client and log in with a fresh access token
+func (mgr *Manager) connectController() error {
+	baseURLStr := fmt.Sprintf("%v://%s.%s:%d/api/v3", mgr.opt.ControllerScheme, pkg.controllerServiceName, mgr.opt.Namespace, pkg.controllerPort)
+	baseURL, err := url.Parse(baseURLStr)
+	if err != nil {
+		return fmt.Errorf("could not parse Controller URL %s: %s", baseURLStr, err.Error())
+	}
+
+	retries := ioclient.Retries{CustomMessage: make(map[string]int)}
+	if mgr.opt.ControllerRetries > 0 {
+		for _, message := range []string{"timeout", "refuse", "credential"} {
+			retries.CustomMessage[message] = mgr.opt.ControllerRetries
+		}
+	}
+	ioClient := ioclient.New(ioclient.Options{
+		BaseURL: baseURL,
+		Retries: &retries,
+		Timeout: 1,
+	})
+
+	// Generate Controller Access Token
+	if err := mgr.loginIofogClient(ioClient); err != nil {
+		return fmt.Errorf("failed to generate Access Token: %s", err.Error())
+	}
+	mgr.log.Info("Logged into Controller API")
 	return nil
 }
 
@@ -231,38 +530,124 @@ func (mgr *Manager) init() (err error) {
 // Query ioFog Controller REST API and compare against cache
 // Make updates to K8s resources as required
 func (mgr *Manager) Run() {
-	// Initialize cache based on K8s API
-	if err := mgr.generateCache(); err != nil {
-		mgr.log.Error(err, "Failed to generate cache")
-		time.Sleep(5 * time.Second)
+	if err := mgr.start(); err != nil {
+		mgr.log.Error(err, "Failed to initialize Proxy")
 	}
+	mgr.flushPlan()
 
 	// Watch Controller API
-	for {
-		time.Sleep(pkg.pollInterval)
-		if err := mgr.run(); err != nil {
-			mgr.log.Info(err.Error(), "Failed in watch loop")
+	mgr.queue.AddAfter(reconcileItem, pkg.pollInterval)
+	for mgr.processNextItem() {
+	}
+}
 
-			baseURLStr := fmt.Sprintf("%v://%s.%s:%d/api/v3", mgr.opt.ControllerScheme, pkg.controllerServiceName, mgr.opt.Namespace, pkg.controllerPort)
-			baseURL, err := url.Parse(baseURLStr)
-			if err != nil {
-				mgr.log.Error(err, "Could not parse Controller URL")
-				return
+// Process one item of the work queue, failed items are retried with backoff
+// Returns false once the queue is shut down
+func (mgr *Manager) processNextItem() bool {
+	item, shutdown := mgr.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer mgr.queue.Done(item)
+
+	switch item {
+	case reconcileItem:
+		if err := mgr.reconcile(); err != nil {
+			policy := mgr.opt.ReconcileRetry
+			mgr.log.Info(err.Error(), "Failed in watch loop", "retries", mgr.queue.NumRequeues(item))
+			if mgr.breaker.isOpen() {
+				// Controller calls are paused, no point in retrying sooner
+				mgr.queue.AddAfter(item, mgr.breaker.cooldown)
+				return true
 			}
-
-			ioClient := ioclient.New(ioclient.Options{
-				BaseURL: baseURL,
-				Timeout: 1,
-			})
-
-			// Generate Controller Access Token
-			if err := mgr.loginIofogClient(ioClient); err != nil {
-				mgr.log.Error(err, "Failed to generate Access Token")
+			if policy.MaxRetries == 0 || mgr.queue.NumRequeues(item) < policy.MaxRetries {
+				mgr.queue.AddRateLimited(item)
+				return true
+			}
+			mgr.log.Info("Giving up retrying reconcile until next poll")
+		}
+		mgr.queue.Forget(item)
+		mgr.queue.AddAfter(item, pkg.pollInterval)
+	case registerItem:
+		if err := mgr.registerPendingAddress(); err != nil {
+			mgr.log.Error(err, "Failed to register Proxy address", "retries", mgr.queue.NumRequeues(item))
+			if policy := mgr.opt.RegistrationRetry; policy.MaxRetries == 0 || mgr.queue.NumRequeues(item) < policy.MaxRetries {
+				mgr.queue.AddRateLimited(item)
+				return true
+			}
+			mgr.log.Info("Giving up registering Proxy address until it changes")
+		}
+		mgr.queue.Forget(item)
+	case linksItem:
+		if err := mgr.writePortLinks(); err != nil {
+			mgr.log.Error(err, "Failed to publish public port links", "retries", mgr.queue.NumRequeues(item))
+			if policy := mgr.opt.RegistrationRetry; policy.MaxRetries == 0 || mgr.queue.NumRequeues(item) < policy.MaxRetries {
+				mgr.queue.AddRateLimited(item)
+				return true
 			}
+			// Queued again by the next cycle
+			mgr.pendingLinks = make(map[int]portLink)
+			mgr.log.Info("Giving up publishing public port links until next poll")
+		}
+		mgr.queue.Forget(item)
+	}
+	return true
+}
 
-			mgr.log.Info("Logged into Controller API")
+// One reconcile cycle, logs back into the Controller on failure
+func (mgr *Manager) reconcile() error {
+	err := mgr.run()
+	if rolloutErr := mgr.checkRollout(); rolloutErr != nil {
+		mgr.log.Error(rolloutErr, "Failed to check Proxy rollout")
+	}
+	if blueGreenErr := mgr.checkBlueGreen(); blueGreenErr != nil {
+		mgr.log.Error(blueGreenErr, "Failed to switch blue/green Proxy")
+	}
+	// Router pods and addresses change independently of the port set
+	if policyErr := mgr.updateProxyNetworkPolicy(); policyErr != nil {
+		mgr.log.Error(policyErr, "Failed to update Proxy NetworkPolicy")
+	}
+	if pruneErr := mgr.pruneProxyConfigs(); pruneErr != nil {
+		mgr.log.Error(pruneErr, "Failed to delete unused Proxy configs")
+	}
+	if trackErr := mgr.trackProxyAddress(); trackErr != nil {
+		mgr.log.Error(trackErr, "Failed to track Proxy address")
+	}
+	if shardErr := mgr.trackShardAddresses(); shardErr != nil {
+		mgr.log.Error(shardErr, "Failed to resolve Proxy shard addresses")
+	}
+	if dnsErr := mgr.syncDNSEndpoint(); dnsErr != nil {
+		mgr.log.Error(dnsErr, "Failed to update Proxy DNSEndpoint")
+	}
+	if linkErr := mgr.publishPortLinks(); linkErr != nil {
+		mgr.log.Error(linkErr, "Failed to publish public port links")
+	}
+	mgr.reportExternalPorts()
+	mgr.persistState()
+	mgr.flushPlan()
+	if err != nil && !errors.Is(err, errCircuitOpen) && mgr.usesController() {
+		if loginErr := mgr.connectController(); loginErr != nil {
+			mgr.log.Error(loginErr, "Failed to log into Controller API")
 		}
 	}
+	return err
+}
+
+// Initialize the cache and bring the existing Proxy in line with the Options
+func (mgr *Manager) start() error {
+	// Router must be known before the Proxy can be reconciled
+	mgr.refreshRouter()
+
+	// Initialize cache based on K8s API
+	if err := mgr.generateCache(); err != nil {
+		return err
+	}
+
+	// Options may have changed since the proxy was created
+	if len(mgr.cache) > 0 {
+		return mgr.updateProxy()
+	}
+	return nil
 }
 
 func (mgr *Manager) generateCache() error {
@@ -270,9 +655,41 @@ func (mgr *Manager) generateCache() error {
 	// Clear the cache
 	mgr.cache = make(portMap)
 
+	// Ports keep their Service shard across restarts
+	mgr.shards = make(map[int]int)
+	if err := mgr.restoreShards(); err != nil {
+		return err
+	}
+	mgr.hostnames = make(map[int]string)
+	if err := mgr.restoreHostnames(); err != nil {
+		return err
+	}
+
+	// Isolated proxies are spread over a Deployment per group
+	if mgr.isIsolated() {
+		mgr.portGroups = make(map[int]string)
+		found, err := mgr.generateIsolatedCache()
+		if err != nil {
+			return err
+		}
+		if !found {
+			mgr.log.Info("Initialized with empty cache")
+			return mgr.restoreState()
+		}
+		mgr.log.Info("Generated cache", "ports", len(mgr.cache))
+		return mgr.restoreState()
+	}
+
+	// Blue/green proxies serve from the color selected by the Service
+	if mgr.isBlueGreen() {
+		if err := mgr.detectActiveColor(); err != nil {
+			return err
+		}
+	}
+
 	// Get deployment
 	proxyKey := k8sclient.ObjectKey{
-		Name:      mgr.opt.ProxyName,
+		Name:      mgr.getProxyDeploymentName(),
 		Namespace: mgr.opt.Namespace,
 	}
 	foundDep := appsv1.Deployment{}
@@ -282,38 +699,50 @@ func (mgr *Manager) generateCache() error {
 		}
 		// Deployment not found, no ports open, nothing to cache
 		mgr.log.Info("Initialized with empty cache")
-		return nil
+		return mgr.restoreState()
+	}
+	if err := mgr.restoreState(); err != nil {
+		return err
+	}
+	if !mgr.isBlueGreen() {
+		mgr.restoreRollout(&foundDep)
 	}
 
 	// Deployment exists, get the config
-	config, err := getProxyConfig(&foundDep)
+	config, err := mgr.readProxyConfig(&foundDep)
 	if err != nil {
 		return err
 	}
 
 	// Get microservices from config
-	configItems := strings.Split(config, ",")
-	for _, configItem := range configItems {
-		// Get microservice and port details from item
-		port, err := decodeMicroservice(configItem)
-		if err != nil {
-			return err
-		}
-		// Update cache
-		mgr.cache[port.Port] = *port
+	ports, err := decodeProxyConfig(config)
+	if err != nil {
+		return err
+	}
+	for _, port := range ports {
+		mgr.cache[port.Port] = port
 	}
 
-	mgr.log.Info("Generated cache", "cache", mgr.cache)
+	mgr.log.Info("Generated cache", "ports", len(mgr.cache))
 	return nil
 }
 
 func (mgr *Manager) run() error {
 	cacheReconciled := false
+	portRemoved := false
 
-	// Get public ports from Controller
-	allBackendPorts, err := mgr.ioClient.GetAllMicroservicePublicPorts()
-	if err != nil {
-		return err
+	// Check whether the default router has moved, the last known router is kept when it cannot be read
+	routerChanged := mgr.refreshRouter()
+
+	// Get public ports from source, the last known response is served while it is unavailable
+	allBackendPorts, sourceErr := mgr.source.GetPublicPorts()
+	if sourceErr == nil {
+		mgr.recordSync(allBackendPorts)
+	} else if !mgr.state.hasResponse {
+		return sourceErr
+	} else {
+		mgr.log.Info("Port source unavailable, serving last known public ports", "error", sourceErr.Error(), "lastSync", mgr.state.lastSync.Format(time.RFC3339))
+		allBackendPorts = mgr.state.response
 	}
 
 	var backendPorts []ioclient.MicroservicePublicPort
@@ -328,52 +757,118 @@ func (mgr *Manager) run() error {
 		}
 	}
 
+	// Drop ports rejected by policy, then ensure each port is claimed by a single microservice
+	backendPorts = mgr.applyPortPolicy(backendPorts)
+	backendPorts = mgr.resolveConflicts(backendPorts)
+
+	// Create map of backend ports
+	backendPortMap := make(map[int]string)
+	portOwners := make(map[int]string)
+	for _, backendPort := range backendPorts {
+		backendPortMap[backendPort.PublicPort.Port] = backendPort.PublicPort.Queue
+		portOwners[backendPort.PublicPort.Port] = backendPort.MicroserviceUUID
+	}
+	mgr.portOwners = portOwners
+
+	// Group each port in isolation mode, a port moving to another group is a change
+	if mgr.isIsolated() {
+		for _, backendPort := range backendPorts {
+			port := backendPort.PublicPort.Port
+			group, err := mgr.getPortGroup(port, backendPort.MicroserviceUUID)
+			if err != nil {
+				return err
+			}
+			if existing, cached := mgr.portGroups[port]; cached && existing != group {
+				cacheReconciled = true
+			}
+			mgr.portGroups[port] = group
+		}
+	}
+
+	// Virtually hosted ports moving to another hostname are a change
+	if mgr.isVirtualHosting() {
+		hostsChanged, err := mgr.assignHostnames(backendPorts)
+		if err != nil {
+			return err
+		}
+		cacheReconciled = cacheReconciled || hostsChanged
+	}
+	dnsChanged, err := mgr.assignDNSNames(backendPorts)
+	if err != nil {
+		return err
+	}
+	cacheReconciled = cacheReconciled || (dnsChanged && mgr.opt.DNSProvider == DNSProviderAnnotation)
+
+	// Protect against mass removal when the source returns a shrunken list
+	removalAllowed, err := mgr.checkRemovals(backendPortMap)
+	if err != nil {
+		return err
+	}
+
+	// Changes are made to a copy of the cache, a dry run keeps the live ports so that unapplied changes are reported again
+	cache := make(portMap, len(mgr.cache))
+	for port, publicPort := range mgr.cache {
+		cache[port] = publicPort
+	}
+
 	// Update Proxy config if new ports are created or queues changed
 	for _, backendPort := range backendPorts {
 		newPort := backendPort.PublicPort
-		existingPort, exists := mgr.cache[newPort.Port]
+		existingPort, exists := cache[newPort.Port]
 		// Microservice already stored in cache
 		if exists {
 			// Check for queue change
 			if existingPort.Queue != newPort.Queue || existingPort.Protocol != newPort.Protocol {
 				cacheReconciled = true
 				// Update cache
-				mgr.cache[newPort.Port] = newPort
+				cache[newPort.Port] = newPort
 			}
 		} else {
 			// New port, update cache
 			cacheReconciled = true
-			mgr.cache[newPort.Port] = newPort
+			cache[newPort.Port] = newPort
 		}
 	}
 
 	// Update Proxy config if ports are deleted
-	// Create map of backend ports
-	backendPortMap := make(map[int]string)
-	for _, backendPort := range backendPorts {
-		backendPortMap[backendPort.PublicPort.Port] = backendPort.PublicPort.Queue
-	}
-	for port := range mgr.cache {
+	for port := range cache {
 		// Cached port does not exist in backend, delete it
-		if _, exists := backendPortMap[port]; !exists {
+		if _, exists := backendPortMap[port]; !exists && removalAllowed {
 			// Cached microservice not found in backend
 			cacheReconciled = true
+			portRemoved = true
 			// Remove microservice from cache
-			delete(mgr.cache, port)
+			delete(cache, port)
+			delete(mgr.portGroups, port)
 		}
 	}
 
-	// Update K8s resources
+	// Update K8s resources once changes settle, the proxy cannot reach a moved router so it is not held back
+	now := time.Now()
 	if cacheReconciled {
-		mgr.log.Info("Reconciled cache", "cache", mgr.cache)
-		return mgr.updateProxy()
+		mgr.pacer.change(now, portRemoved && mgr.opt.UrgentRemovals)
 	}
-
-	return nil
+	if routerChanged && len(cache) > 0 {
+		mgr.log.Info("Default router changed, updating Proxy")
+		mgr.pacer.change(now, true)
+	}
+	if mgr.opt.DryRun {
+		live, liveShards, liveShardPorts := mgr.cache, mgr.shards, mgr.shardPorts
+		defer func() { mgr.cache, mgr.shards, mgr.shardPorts = live, liveShards, liveShardPorts }()
+	}
+	mgr.cache = cache
+	mgr.assignShards()
+	if err := mgr.rolloutPending(); err != nil {
+		return err
+	}
+	return sourceErr
 }
 
 // Delete K8s resources for an HTTP Proxy created for a Microservice
 func (mgr *Manager) deleteProxyDeployment() error {
+	if mgr.isBlueGreen() {
+		return mgr.deleteBlueGreenDeployments()
+	}
 	dep := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
 		Name:      mgr.opt.ProxyName,
 		Namespace: mgr.opt.Namespace,
@@ -381,6 +876,7 @@ func (mgr *Manager) deleteProxyDeployment() error {
 	if err := mgr.delete(dep); err != nil {
 		return err
 	}
+	mgr.rollout.revision = ""
 	return nil
 }
 
@@ -399,6 +895,9 @@ func (mgr *Manager) deleteProxyService() error {
 	if err := mgr.delete(svc); err != nil {
 		return err
 	}
+	if mgr.opt.DryRun {
+		return nil
+	}
 	// Wait for service to be gone
 	timeout := time.Second * 60
 	for start := time.Now(); time.Since(start) < timeout; {
@@ -417,6 +916,10 @@ func (mgr *Manager) deleteProxyService() error {
 
 // Create or update an HTTP Proxy instance for a Microservice
 func (mgr *Manager) updateProxy() error {
+	if mgr.router.Host == "" {
+		return errors.New("router address is not known, cannot update Proxy")
+	}
+
 	// Key to check resources don't already exist
 	proxyKey := k8sclient.ObjectKey{
 		Name:      mgr.opt.ProxyName,
@@ -425,7 +928,18 @@ func (mgr *Manager) updateProxy() error {
 
 	// Deployment
 	foundDep := appsv1.Deployment{}
-	if err := mgr.k8sClient.Get(context.TODO(), proxyKey, &foundDep); err == nil {
+	if mgr.isIsolated() {
+		if err := mgr.updateProxyIsolated(); err != nil {
+			return err
+		}
+		if mgr.opt.IsolatedServices {
+			return mgr.updateProxyNetworkPolicy()
+		}
+	} else if mgr.isBlueGreen() {
+		if err := mgr.updateProxyBlueGreen(); err != nil {
+			return err
+		}
+	} else if err := mgr.k8sClient.Get(context.TODO(), proxyKey, &foundDep); err == nil {
 		// Existing deployment found, update the proxy configuration
 		if err := mgr.updateProxyDeployment(&foundDep); err != nil {
 			return err
@@ -435,21 +949,18 @@ func (mgr *Manager) updateProxy() error {
 			return err
 		}
 		// Create new deployment
-		dep := newProxyDeployment(
-			mgr.opt.Namespace,
-			mgr.opt.ProxyName,
-			mgr.opt.ProxyImage,
-			mgr.opt.ImagePullSecret,
-			1,
-			createProxyConfig(mgr.cache),
-			mgr.opt.RouterAddress,
-			mgr.opt.RouterServerName,
-			mgr.opt.RouterTransport,
-		)
+		config := mgr.renderProxyConfig(mgr.cache)
+		if err := mgr.storeProxyConfig(config); err != nil {
+			return err
+		}
+		dep := mgr.newProxyDeployment(config)
+		revision := getRevision(&dep.Spec.Template)
+		setRevision(dep, revision)
 		mgr.setOwnerReference(dep)
-		if err := mgr.k8sClient.Create(context.TODO(), dep); err != nil {
+		if err := mgr.create(dep); err != nil {
 			return err
 		}
+		mgr.beginRollout(dep, revision)
 	}
 
 	// Service
@@ -464,94 +975,184 @@ func (mgr *Manager) updateProxy() error {
 			return err
 		}
 		// Create new service if ports exist
-		svc := newProxyService(mgr.opt.Namespace, mgr.opt.ProxyName, mgr.cache, mgr.opt.ProxyServiceType, mgr.opt.ProxyServiceAnnotations)
-		mgr.setOwnerReference(svc)
-		if err := mgr.k8sClient.Create(context.TODO(), svc); err != nil {
-			return err
+		svc := mgr.newProxyService()
+		if len(svc.Spec.Ports) != 0 {
+			mgr.setOwnerReference(svc)
+			if err := mgr.create(svc); err != nil {
+				return err
+			}
+			// Trigger address registration for Controller
+			mgr.registerAddress(mgr.opt.ProxyExternalAddress)
 		}
-		// Trigger address registration for Controller
-		mgr.addressChan <- mgr.opt.ProxyExternalAddress
+	}
+	if err := mgr.updateShardServices(); err != nil {
+		return err
+	}
+	if err := mgr.updateVirtualHosts(); err != nil {
+		return err
 	}
 
-	return nil
+	// NetworkPolicy
+	return mgr.updateProxyNetworkPolicy()
 }
 
-func (mgr *Manager) registerProxyAddress() {
-	timeout := int64(60)
-	var err error
-
-	for {
-		// Wait for signal
-		addr := <-mgr.addressChan
-
-		if addr == "" {
-			// Wait for LB Service
-			addr, err = mgr.waitClient.WaitForLoadBalancer(mgr.opt.Namespace, mgr.opt.ProxyName, timeout)
-			if err != nil {
-				mgr.log.Error(err, "Failed to find IP address of Proxy Service")
-				// Wait
-				time.Sleep(5 * time.Second)
-				// Retry
-				mgr.addressChan <- ""
-				continue
-			}
+// Trigger registration of the proxy address with the Controller
+func (mgr *Manager) registerAddress(addr string) {
+	if !mgr.usesController() {
+		return
+	}
+	if mgr.opt.DryRun {
+		value := addr
+		if value == "" {
+			value = "<address of Service " + mgr.opt.ProxyName + ">"
 		}
+		mgr.plan.record(Change{
+			Action: actionRegister,
+			Kind:   "ControllerConfig",
+			Name:   defaultProxyHostKey,
+			Diff:   diffLines("", value),
+		})
+		return
+	}
+	mgr.pendingAddress = addr
+	mgr.queue.Add(registerItem)
+}
 
-		// Attempt to register
-		err = mgr.ioClient.PutDefaultProxy(addr)
-		if err != nil {
-			mgr.log.Error(err, "Failed to register Proxy address "+addr)
-			// Wait
-			time.Sleep(5 * time.Second)
-			// Retry with LB addr
-			mgr.addressChan <- addr
-			continue
+// Register the pending address with the Controller, resolving it from the Proxy Service if needed
+func (mgr *Manager) registerPendingAddress() (err error) {
+	addr := mgr.pendingAddress
+	if addr == "" {
+		if addr, err = mgr.resolveProxyAddress(); err != nil {
+			return err
 		}
+		if addr == "" {
+			return fmt.Errorf("address of Proxy Service is not available yet using %s resolver", mgr.opt.AddressResolver)
+		}
+	}
 
-		mgr.log.Info("Successfully registered Proxy address " + addr)
+	// Attempt to register
+	if err = mgr.callController(func(client *ioclient.Client) error {
+		return client.PutDefaultProxy(addr)
+	}); err != nil {
+		return fmt.Errorf("could not register Proxy address %s: %s", addr, err.Error())
 	}
+
+	mgr.state.address = addr
+	mgr.log.Info("Successfully registered Proxy address " + addr)
+	return nil
 }
 
+// Reconcile the proxy Service with the cache, only updating when something differs
 func (mgr *Manager) updateProxyService(foundSvc *corev1.Service) error {
-	modifyServiceSpec(foundSvc, mgr.cache)
+	desired := mgr.newProxyService()
 
 	// Cannot update service to have 0 ports, delete it
-	if len(foundSvc.Spec.Ports) == 0 {
+	if len(desired.Spec.Ports) == 0 {
 		// Delete empty service
 		return mgr.deleteProxyService()
 	}
 
+	if !reconcileProxyService(foundSvc, desired) {
+		return nil
+	}
+
 	// Update the service with new ports
-	if err := mgr.k8sClient.Update(context.TODO(), foundSvc); err != nil {
+	if err := mgr.update(foundSvc); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// TODO: Replace this function with logic to update config in Proxy without editing the deployment
+// Reconcile the proxy Deployment with the desired template, only updating when something differs
 func (mgr *Manager) updateProxyDeployment(foundDep *appsv1.Deployment) error {
 	// Generate config
-	config := createProxyConfig(mgr.cache)
+	config := mgr.renderProxyConfig(mgr.cache)
 
 	if config == "" {
 		// Delete unneeded resource
 		return mgr.deleteProxyDeployment()
 	}
 
-	// Save the config to deployment
-	if err := updateProxyConfig(foundDep, config); err != nil {
+	// A revision that was rolled back is retried after a backoff, until then the cache follows the proxy
+	desired := mgr.newProxyDeployment(config)
+	revision := getRevision(&desired.Spec.Template)
+	if mgr.rollout.isHeldBack(revision) {
+		mgr.log.Info("Not applying Proxy revision which failed to roll out", "revision", revision, "retryAt", mgr.rollout.retryAt.Format(time.RFC3339))
+		return mgr.revertCache(foundDep)
+	}
+
+	// Reconcile the whole template, not just the config
+	if !reconcileProxyDeployment(foundDep, desired) {
+		return nil
+	}
+	if err := mgr.storeProxyConfig(config); err != nil {
 		return err
 	}
 
 	// Update the deployment
-	if err := mgr.k8sClient.Update(context.TODO(), foundDep); err != nil {
+	setRevision(foundDep, revision)
+	if err := mgr.update(foundDep); err != nil {
 		return err
 	}
+	mgr.beginRollout(foundDep, revision)
 	return nil
 }
 
+// Desired state of the proxy Service based on the cache and manager Options
+func (mgr *Manager) newProxyService() *corev1.Service {
+	svc := newProxyService(mgr.opt.Namespace, mgr.opt.ProxyName, mgr.getShardPorts(0), mgr.opt.ProxyServiceType, mgr.opt.ProxyServiceAnnotations, mgr.opt.ProxyExternalIPs, mgr.opt.PortMappings)
+	svc.Spec.LoadBalancerIP = mgr.opt.ProxyLoadBalancerIP
+	mgr.pinNodePorts(svc)
+	if mgr.isSharded() {
+		svc.Labels[shardLabel] = "0"
+	}
+	mgr.setDNSAnnotations(svc, mgr.getShardPorts(0), true)
+	if mgr.isIsolated() {
+		setTargetPortNames(svc)
+	}
+	if mgr.isBlueGreen() && mgr.blueGreen.active != "" {
+		svc.Spec.Selector = map[string]string{
+			"name":     mgr.opt.ProxyName,
+			colorLabel: mgr.blueGreen.active,
+		}
+	}
+	if mgr.isBlueGreen() {
+		annotations := map[string]string{blueGreenAnnotation: mgr.blueGreen.encode()}
+		for key, value := range svc.Annotations {
+			annotations[key] = value
+		}
+		svc.Annotations = annotations
+	}
+	return svc
+}
+
+// Desired state of the proxy Deployment based on the manager Options
+func (mgr *Manager) newProxyDeployment(config string) *appsv1.Deployment {
+	dep := newProxyDeployment(
+		mgr.opt.Namespace,
+		mgr.opt.ProxyName,
+		mgr.opt.ProxyImage,
+		mgr.opt.ImagePullSecret,
+		1,
+		config,
+		mgr.router,
+	)
+	if mgr.storesConfigInConfigMap() {
+		mgr.setProxyConfigMap(dep, config)
+	}
+	return dep
+}
+
 func (mgr *Manager) delete(obj k8sclient.Object) error {
+	if mgr.opt.DryRun {
+		mgr.plan.record(Change{
+			Action: actionDelete,
+			Kind:   getKind(obj),
+			Name:   obj.GetName(),
+		})
+		return nil
+	}
 	if err := mgr.k8sClient.Delete(context.Background(), obj); err != nil {
 		if !k8serrors.IsNotFound(err) {
 			return err
@@ -0,0 +1,144 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCheckOptions(t *testing.T) {
+	testCases := []struct {
+		name  string
+		apply func(opt *Options)
+		valid bool
+	}{
+		{
+			name:  "defaults",
+			apply: func(opt *Options) {},
+			valid: true,
+		},
+		{
+			name:  "config file without proxy support",
+			apply: func(opt *Options) { opt.ProxyConfigStorage = ConfigStorageConfigMap },
+		},
+		{
+			name: "config file with proxy support",
+			apply: func(opt *Options) {
+				opt.ProxyConfigStorage = ConfigStorageConfigMap
+				opt.ProxyFeatures = []string{ProxyFeatureConfigFile}
+			},
+			valid: true,
+		},
+		{
+			name: "client address without proxy support",
+			apply: func(opt *Options) {
+				opt.ClientAddress = []ClientAddressRule{{ForwardClientAddress: true}}
+			},
+		},
+		{
+			name: "client address with proxy support",
+			apply: func(opt *Options) {
+				opt.ClientAddress = []ClientAddressRule{{ForwardClientAddress: true}}
+				opt.ProxyFeatures = []string{ProxyFeatureClientAddress}
+			},
+			valid: true,
+		},
+		{
+			name:  "resolver requiring another Service type",
+			apply: func(opt *Options) { opt.AddressResolver = AddressResolverNodePort },
+		},
+		{
+			name: "resolver with its Service type",
+			apply: func(opt *Options) {
+				opt.AddressResolver = AddressResolverNodePort
+				opt.ProxyServiceType = "NodePort"
+			},
+			valid: true,
+		},
+		{
+			name:  "invalid node port range",
+			apply: func(opt *Options) { opt.NodePortRange = PortRange{Start: 32767, End: 30000} },
+		},
+		{
+			name:  "isolated Services without shared address",
+			apply: func(opt *Options) { opt.Isolation, opt.IsolatedServices = IsolationPort, true },
+		},
+		{
+			name: "isolated Services sharing a load balancer IP",
+			apply: func(opt *Options) {
+				opt.Isolation, opt.IsolatedServices = IsolationPort, true
+				opt.ProxyLoadBalancerIP = "203.0.113.10"
+			},
+			valid: true,
+		},
+		{
+			name: "isolated Services with cluster IPs",
+			apply: func(opt *Options) {
+				opt.Isolation, opt.IsolatedServices = IsolationPort, true
+				opt.ProxyServiceType = "ClusterIP"
+			},
+		},
+		{
+			name: "virtual hosting behind restricted NetworkPolicy",
+			apply: func(opt *Options) {
+				opt.HTTPBaseDomain = "apps.example.com"
+				opt.ProxyNetworkPolicy = true
+				opt.NetworkPolicyCIDRs = []string{"203.0.113.0/24"}
+			},
+		},
+		{
+			name: "virtual hosting allowing the Ingress controller",
+			apply: func(opt *Options) {
+				opt.HTTPBaseDomain = "apps.example.com"
+				opt.ProxyNetworkPolicy = true
+				opt.NetworkPolicyCIDRs = []string{"203.0.113.0/24"}
+				opt.HTTPIngressNamespaceSelector = map[string]string{corev1.LabelMetadataName: "ingress-nginx"}
+			},
+			valid: true,
+		},
+		{
+			name:  "Service shards without shared address",
+			apply: func(opt *Options) { opt.MaxServicePorts = 50 },
+		},
+		{
+			name: "Service shards sharing external IPs",
+			apply: func(opt *Options) {
+				opt.MaxServicePorts = 50
+				opt.AddressResolver = AddressResolverExternalIP
+				opt.ProxyExternalIPs = []string{"203.0.113.10"}
+			},
+			valid: true,
+		},
+		{
+			name: "Service shards with cluster IPs",
+			apply: func(opt *Options) {
+				opt.MaxServicePorts = 50
+				opt.AddressResolver = AddressResolverClusterIP
+				opt.ProxyServiceType = "ClusterIP"
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opt := newTestOptions()
+			tc.apply(opt)
+			mgr := newTestManager(opt, newFakeClient(), &fakePortSource{})
+			if err := mgr.checkOptions(); (err == nil) != tc.valid {
+				t.Errorf("Expected valid %v, got %v", tc.valid, err)
+			}
+		})
+	}
+}
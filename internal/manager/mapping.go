@@ -0,0 +1,140 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PortSelector matches a single Port or a Range of ports, optionally of one protocol
+type PortSelector struct {
+	Protocol string     `json:"protocol,omitempty"`
+	Port     int        `json:"port,omitempty"`
+	Range    *PortRange `json:"range,omitempty"`
+}
+
+func (selector *PortSelector) matches(port ioclient.PublicPort) bool {
+	if selector.Protocol != "" && !strings.EqualFold(selector.Protocol, port.Protocol) {
+		return false
+	}
+	if selector.Range != nil {
+		return selector.Range.contains(port.Port)
+	}
+	return selector.Port == port.Port
+}
+
+// PortMapping exposes public ports on other external port numbers, the proxy keeps listening on the public port
+// The first matching rule applies, ExternalPort maps a single port and Offset shifts every port of the rule
+type PortMapping struct {
+	PortSelector
+	ExternalPort int `json:"externalPort,omitempty"`
+	Offset       int `json:"offset,omitempty"`
+}
+
+// External port of a public port, the public port itself when no rule matches
+func getExternalPort(mappings []PortMapping, port ioclient.PublicPort) int {
+	for idx := range mappings {
+		mapping := &mappings[idx]
+		if !mapping.matches(port) {
+			continue
+		}
+		if mapping.ExternalPort != 0 {
+			return mapping.ExternalPort
+		}
+		return port.Port + mapping.Offset
+	}
+	return port.Port
+}
+
+func checkPortMappings(mappings []PortMapping) error {
+	for idx := range mappings {
+		if mappings[idx].Range == nil {
+			continue
+		}
+		if err := mappings[idx].Range.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reject public ports whose external port is invalid, outside the node port range of the nodeport strategy or already taken by another public port
+// Ports already exposed keep their external port, others claim theirs in ascending order
+// Claims of the same public port by several microservices are left to conflict resolution
+func (mgr *Manager) checkExternalPorts(ports []ioclient.MicroservicePublicPort, reject func(*ioclient.MicroservicePublicPort, string)) []ioclient.MicroservicePublicPort {
+	if len(mgr.opt.PortMappings) == 0 && !mgr.pinsNodePorts() {
+		return ports
+	}
+	sorted := mgr.sortByPriority(ports)
+
+	taken := make(map[int]int)
+	accepted := make(map[ioclient.MicroservicePublicPort]bool)
+	for idx := range sorted {
+		port := &sorted[idx]
+		if mgr.isVirtualHosted(port.PublicPort) {
+			accepted[*port] = true
+			continue
+		}
+		external := getExternalPort(mgr.opt.PortMappings, port.PublicPort)
+		if external < 1 || external > 65535 {
+			reject(port, fmt.Sprintf("external port %d is out of range", external))
+			continue
+		}
+		if mgr.pinsNodePorts() && !mgr.opt.NodePortRange.contains(external) {
+			reject(port, fmt.Sprintf("external port %d is outside the node port range %d-%d", external, mgr.opt.NodePortRange.Start, mgr.opt.NodePortRange.End))
+			continue
+		}
+		if owner, exists := taken[external]; exists && owner != port.PublicPort.Port {
+			reject(port, fmt.Sprintf("external port %d is already used by public port %d", external, owner))
+			continue
+		}
+		taken[external] = port.PublicPort.Port
+		accepted[*port] = true
+	}
+
+	// Preserve the order of the source
+	allowed := make([]ioclient.MicroservicePublicPort, 0, len(accepted))
+	for _, port := range ports {
+		if accepted[port] {
+			allowed = append(allowed, port)
+		}
+	}
+	return allowed
+}
+
+// Report public ports exposed on another external port through Events whenever their mapping changes
+// The external port reaches the Controller with the port links written by publishPortLinks
+func (mgr *Manager) reportExternalPorts() {
+	if len(mgr.opt.PortMappings) == 0 {
+		return
+	}
+	mapped := make(map[int]int)
+	for _, port := range sortPorts(mgr.getServicePorts()) {
+		external := getExternalPort(mgr.opt.PortMappings, port)
+		if external == port.Port {
+			continue
+		}
+		mapped[port.Port] = external
+		if mgr.externalPorts[port.Port] != external {
+			msg := fmt.Sprintf("Public port %d of queue %s is exposed on external port %d", port.Port, port.Queue, external)
+			mgr.log.Info(msg)
+			mgr.recordEvent(corev1.EventTypeNormal, "PublicPortRemapped", msg)
+		}
+	}
+	mgr.externalPorts = mapped
+}
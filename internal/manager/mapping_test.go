@@ -0,0 +1,123 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReconcilePortMappings(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.PortMappings = []PortMapping{
+		{PortSelector: PortSelector{Port: 5000}, ExternalPort: 443},
+		{PortSelector: PortSelector{Protocol: "tcp", Range: &PortRange{Start: 6000, End: 6999}}, Offset: 20000},
+	}
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, source)
+
+	// 26001 collides with the external port of 6001
+	source.set(newPublicPort("a", "http", 5000), newPublicPort("b", "tcp", 6001), newPublicPort("c", "tcp", 26001), newPublicPort("d", "http", 6002))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	dep, svc := getProxyObjects(t, mgr)
+	if config, _ := getProxyConfig(dep); config != "http:5000=>amqp:a,tcp:6001=>amqp:b,http:6002=>amqp:d" {
+		t.Errorf("Expected proxy to listen on public ports, got %s", config)
+	}
+	expected := map[int32]int{443: 5000, 26001: 6001, 6002: 6002}
+	if len(svc.Spec.Ports) != len(expected) {
+		t.Fatalf("Expected %d Service ports, got %v", len(expected), svc.Spec.Ports)
+	}
+	for _, port := range svc.Spec.Ports {
+		if expected[port.Port] != port.TargetPort.IntValue() {
+			t.Errorf("Expected external port %d to target %d, got %d", port.Port, expected[port.Port], port.TargetPort.IntValue())
+		}
+	}
+	if _, rejected := mgr.rejections["c/26001"]; !rejected {
+		t.Errorf("Expected colliding public port to be rejected, got %v", mgr.rejections)
+	}
+}
+
+// Exposed public port keeps its external port when a lower public port maps onto it
+func TestReconcilePortMappingsExposedFirst(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.PortMappings = []PortMapping{
+		{PortSelector: PortSelector{Port: 5000}, ExternalPort: 443},
+		{PortSelector: PortSelector{Port: 6000}, ExternalPort: 443},
+	}
+	mgr := newTestManager(opt, newFakeClient(), source)
+
+	source.set(newPublicPort("b", "tcp", 6000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 6000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, exposed := mgr.cache[6000]; !exposed {
+		t.Errorf("Expected exposed port to keep its external port, got %v", mgr.cache)
+	}
+	if _, rejected := mgr.rejections["a/5000"]; !rejected {
+		t.Errorf("Expected new port to be rejected, got %v", mgr.rejections)
+	}
+}
+
+// Remapped ports are written back to the Controller without PublishPortLinks, other ports are left alone
+func TestRemappedPortLinks(t *testing.T) {
+	source := &fakePortSource{}
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("a", "tcp", 5001))
+	opt := newTestOptions()
+	opt.ProxyExternalAddress = "203.0.113.10"
+	opt.AddressResolver = AddressResolverStatic
+	opt.PortMappings = []PortMapping{{PortSelector: PortSelector{Port: 5001}, ExternalPort: 443}}
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, source)
+	ctrl := newFakeController(t, mgr, map[string]string{
+		"a": `{"ports":[{"internal":80,"external":0,"public":{"protocol":"tcp","enabled":true,"router":{"host":"router","port":5000}}},` +
+			`{"internal":81,"external":0,"public":{"protocol":"tcp","enabled":true,"router":{"host":"router","port":5001}}}]}`,
+	})
+	if err := mgr.reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	for mgr.queue.Len() != 0 {
+		mgr.processNextItem()
+	}
+
+	expected := map[string]string{"a/81": "tcp tcp://203.0.113.10:443"}
+	if fmt.Sprint(ctrl.links) != fmt.Sprint(expected) {
+		t.Errorf("Expected links %v, got %v", expected, ctrl.links)
+	}
+	if events := getEvents(k8sClient, "PublicPortLink"); len(events) != 0 {
+		t.Errorf("Expected no PublicPortLink Events without PublishPortLinks, got %d", len(events))
+	}
+}
+
+func TestCheckPortMappings(t *testing.T) {
+	for _, test := range []struct {
+		mappings []PortMapping
+		valid    bool
+	}{
+		{mappings: []PortMapping{{PortSelector: PortSelector{Port: 5000}, ExternalPort: 443}}, valid: true},
+		{mappings: []PortMapping{{PortSelector: PortSelector{Range: &PortRange{Start: 6000, End: 6999}}, Offset: 100}}, valid: true},
+		{mappings: []PortMapping{{PortSelector: PortSelector{Range: &PortRange{Start: 6999, End: 6000}}, Offset: 100}}},
+	} {
+		if err := checkPortMappings(test.mappings); (err == nil) != test.valid {
+			t.Errorf("Mappings %v: expected valid %v, got %v", test.mappings, test.valid, err)
+		}
+	}
+}
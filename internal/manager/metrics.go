@@ -0,0 +1,57 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"expvar"
+)
+
+// Metrics are published through expvar, each map is keyed by proxy name
+var metrics = struct {
+	portConflicts        *expvar.Map
+	activePortConflicts  *expvar.Map
+	rejectedPorts        *expvar.Map
+	activeRejectedPorts  *expvar.Map
+	deletionGuardTrips   *expvar.Map
+	secondsSinceSync     *expvar.Map
+	addressRegistrations *expvar.Map
+	circuitBreakerTrips  *expvar.Map
+	proxyUpdates         *expvar.Map
+	pendingRollouts      *expvar.Map
+	rolloutsCompleted    *expvar.Map
+	rolloutsFailed       *expvar.Map
+	rolloutFailing       *expvar.Map
+	rollbacks            *expvar.Map
+}{
+	portConflicts:        expvar.NewMap("port_manager_port_conflicts_total"),
+	activePortConflicts:  expvar.NewMap("port_manager_active_port_conflicts"),
+	rejectedPorts:        expvar.NewMap("port_manager_rejected_ports_total"),
+	activeRejectedPorts:  expvar.NewMap("port_manager_active_rejected_ports"),
+	deletionGuardTrips:   expvar.NewMap("port_manager_deletion_guard_trips_total"),
+	secondsSinceSync:     expvar.NewMap("port_manager_seconds_since_sync"),
+	addressRegistrations: expvar.NewMap("port_manager_address_registrations_total"),
+	circuitBreakerTrips:  expvar.NewMap("port_manager_circuit_breaker_trips_total"),
+	proxyUpdates:         expvar.NewMap("port_manager_proxy_updates_total"),
+	pendingRollouts:      expvar.NewMap("port_manager_pending_proxy_rollouts"),
+	rolloutsCompleted:    expvar.NewMap("port_manager_proxy_rollouts_completed_total"),
+	rolloutsFailed:       expvar.NewMap("port_manager_proxy_rollouts_failed_total"),
+	rolloutFailing:       expvar.NewMap("port_manager_proxy_rollout_failing"),
+	rollbacks:            expvar.NewMap("port_manager_proxy_rollbacks_total"),
+}
+
+func setGauge(metric *expvar.Map, key string, value int64) {
+	gauge := new(expvar.Int)
+	gauge.Set(value)
+	metric.Set(key, gauge)
+}
@@ -0,0 +1,283 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	routerAMQPPort  = 5672
+	routerAMQPSPort = 5671
+	dnsPort         = 53
+)
+
+// Destination of proxy egress to the router
+type routerPeer struct {
+	peer *networkingv1.NetworkPolicyPeer // Nil accepts any destination on the port
+	port intstr.IntOrString
+	dns  bool // Router is addressed by hostname
+}
+
+// Restrict proxy pods to ingress on public ports and egress to the router
+func newProxyNetworkPolicy(namespace, name string, ports portMap, cidrs []string, namespaceSelector map[string]string, router routerPeer) *networkingv1.NetworkPolicy {
+	labels := map[string]string{
+		"name": name,
+	}
+	tcp := corev1.ProtocolTCP
+	udp := corev1.ProtocolUDP
+
+	// Ingress on public ports, optionally restricted to CIDRs and namespaces
+	ingressPorts := getNetworkPolicyPorts(ports)
+	var ingressPeers []networkingv1.NetworkPolicyPeer
+	for _, cidr := range cidrs {
+		ingressPeers = append(ingressPeers, networkingv1.NetworkPolicyPeer{
+			IPBlock: &networkingv1.IPBlock{CIDR: cidr},
+		})
+	}
+	if len(namespaceSelector) != 0 {
+		ingressPeers = append(ingressPeers, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: namespaceSelector},
+		})
+	}
+
+	// Egress to the router, DNS is allowed when the router is addressed by hostname
+	routerRule := networkingv1.NetworkPolicyEgressRule{
+		Ports: []networkingv1.NetworkPolicyPort{
+			{
+				Protocol: &tcp,
+				Port:     &router.port,
+			},
+		},
+	}
+	if router.peer != nil {
+		routerRule.To = []networkingv1.NetworkPolicyPeer{*router.peer}
+	}
+	egress := []networkingv1.NetworkPolicyEgressRule{routerRule}
+	if router.dns {
+		dns := intstr.FromInt(dnsPort)
+		egress = append(egress, networkingv1.NetworkPolicyEgressRule{
+			Ports: []networkingv1.NetworkPolicyPort{
+				{
+					Protocol: &udp,
+					Port:     &dns,
+				},
+				{
+					Protocol: &tcp,
+					Port:     &dns,
+				},
+			},
+		})
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			PolicyTypes: []networkingv1.PolicyType{
+				networkingv1.PolicyTypeIngress,
+				networkingv1.PolicyTypeEgress,
+			},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					Ports: ingressPorts,
+					From:  ingressPeers,
+				},
+			},
+			Egress: egress,
+		},
+	}
+}
+
+func getNetworkPolicyPorts(ports portMap) []networkingv1.NetworkPolicyPort {
+	tcp := corev1.ProtocolTCP
+	portNumbers := make([]int, 0, len(ports))
+	for port := range ports {
+		portNumbers = append(portNumbers, port)
+	}
+	sort.Ints(portNumbers)
+	policyPorts := make([]networkingv1.NetworkPolicyPort, 0, len(portNumbers))
+	for _, port := range portNumbers {
+		target := intstr.FromInt(port)
+		policyPorts = append(policyPorts, networkingv1.NetworkPolicyPort{
+			Protocol: &tcp,
+			Port:     &target,
+		})
+	}
+	return policyPorts
+}
+
+func getRouterPort(router routerInfo) int {
+	if router.Port != 0 {
+		return router.Port
+	}
+	if router.Transport != "" {
+		return routerAMQPSPort
+	}
+	return routerAMQPPort
+}
+
+// Resolve the destination of proxy traffic to the router
+// Policies match traffic to a Service after its ClusterIP is translated, so in-cluster routers are selected by their pods
+// External routers are matched by IP, hostnames outside the cluster cannot be expressed and any destination is accepted
+func (mgr *Manager) getRouterPeer() (routerPeer, error) {
+	port := getRouterPort(mgr.router)
+	ip := net.ParseIP(mgr.router.Host)
+	dest := routerPeer{
+		port: intstr.FromInt(port),
+		dns:  ip == nil,
+	}
+	svc, err := mgr.findRouterService(mgr.router.Host, ip)
+	if err != nil {
+		return dest, err
+	}
+	if svc != nil && len(svc.Spec.Selector) != 0 {
+		peer := networkingv1.NetworkPolicyPeer{
+			PodSelector: &metav1.LabelSelector{MatchLabels: svc.Spec.Selector},
+		}
+		if svc.Namespace != mgr.opt.Namespace {
+			peer.NamespaceSelector = &metav1.LabelSelector{
+				MatchLabels: map[string]string{corev1.LabelMetadataName: svc.Namespace},
+			}
+		}
+		dest.peer = &peer
+		for _, svcPort := range svc.Spec.Ports {
+			if int(svcPort.Port) == port && svcPort.TargetPort != (intstr.IntOrString{}) {
+				dest.port = svcPort.TargetPort
+			}
+		}
+		return dest, nil
+	}
+	if ip != nil {
+		mask := "/32"
+		if ip.To4() == nil {
+			mask = "/128"
+		}
+		dest.peer = &networkingv1.NetworkPolicyPeer{
+			IPBlock: &networkingv1.IPBlock{CIDR: ip.String() + mask},
+		}
+	}
+	return dest, nil
+}
+
+// Find the Service addressed by the router host, by ClusterIP in the manager's namespace or by Service DNS name
+// Returns nil when the router is not a Service visible to the manager
+func (mgr *Manager) findRouterService(host string, ip net.IP) (*corev1.Service, error) {
+	if ip != nil {
+		svcs := corev1.ServiceList{}
+		if err := mgr.k8sClient.List(context.TODO(), &svcs, k8sclient.InNamespace(mgr.opt.Namespace)); err != nil {
+			return nil, err
+		}
+		for idx := range svcs.Items {
+			svc := &svcs.Items[idx]
+			for _, clusterIP := range append([]string{svc.Spec.ClusterIP}, svc.Spec.ClusterIPs...) {
+				if clusterIP == ip.String() {
+					return svc, nil
+				}
+			}
+		}
+		return nil, nil
+	}
+
+	// <name>, <name>.<namespace> or <name>.<namespace>.svc[.<cluster domain>]
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	svcKey := k8sclient.ObjectKey{
+		Name:      labels[0],
+		Namespace: mgr.opt.Namespace,
+	}
+	if len(labels) > 1 {
+		if len(labels) > 2 && labels[2] != "svc" {
+			return nil, nil
+		}
+		svcKey.Namespace = labels[1]
+	}
+	svc := corev1.Service{}
+	if err := mgr.k8sClient.Get(context.TODO(), svcKey, &svc); err != nil {
+		if k8serrors.IsNotFound(err) || k8serrors.IsForbidden(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &svc, nil
+}
+
+// Create, update or delete the NetworkPolicy of the proxy to match the cache
+func (mgr *Manager) updateProxyNetworkPolicy() error {
+	if !mgr.opt.ProxyNetworkPolicy {
+		return nil
+	}
+
+	policyKey := k8sclient.ObjectKey{
+		Name:      mgr.opt.ProxyName,
+		Namespace: mgr.opt.Namespace,
+	}
+	found := networkingv1.NetworkPolicy{}
+	err := mgr.k8sClient.Get(context.TODO(), policyKey, &found)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	// No ports, no proxy pods to protect
+	if len(mgr.cache) == 0 {
+		if exists {
+			return mgr.delete(&found)
+		}
+		return nil
+	}
+
+	router, err := mgr.getRouterPeer()
+	if err != nil {
+		return err
+	}
+	ports := mgr.cache
+	if mgr.allowsIngressController() {
+		ports = mgr.getServicePorts()
+	}
+	policy := newProxyNetworkPolicy(
+		mgr.opt.Namespace,
+		mgr.opt.ProxyName,
+		ports,
+		mgr.opt.NetworkPolicyCIDRs,
+		mgr.opt.NetworkPolicyNamespaceSelector,
+		router,
+	)
+	mgr.setIngressControllerRule(policy)
+	if !exists {
+		mgr.setOwnerReference(policy)
+		return mgr.create(policy)
+	}
+	if equality.Semantic.DeepEqual(found.Spec, policy.Spec) {
+		return nil
+	}
+	found.Spec = policy.Spec
+	return mgr.update(&found)
+}
@@ -0,0 +1,122 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestProxyNetworkPolicyRouter(t *testing.T) {
+	routerSvc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: "10.0.0.10",
+			Selector:  map[string]string{"app": "router"},
+			Ports: []corev1.ServicePort{
+				{Port: routerAMQPPort, TargetPort: intstr.FromString("amqp")},
+			},
+		},
+	}
+	cases := []struct {
+		name     string
+		host     string
+		selector bool   // Egress selects router pods
+		cidr     string // Egress is restricted to an IP
+		dns      bool
+	}{
+		{name: "service name", host: "router", selector: true, dns: true},
+		{name: "service DNS name", host: "router.default.svc.cluster.local", selector: true, dns: true},
+		{name: "cluster IP", host: "10.0.0.10", selector: true},
+		{name: "external IP", host: "192.0.2.1", cidr: "192.0.2.1/32"},
+		{name: "external hostname", host: "router.example.com", dns: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			k8sClient := newFakeClient()
+			if err := k8sClient.Create(context.TODO(), routerSvc.DeepCopy()); err != nil {
+				t.Fatal(err)
+			}
+			opt := newTestOptions()
+			opt.ProxyNetworkPolicy = true
+			opt.RouterAddress = tc.host
+			source := &fakePortSource{}
+			mgr := newTestManager(opt, k8sClient, source)
+			source.set(newPublicPort("a", "tcp", 5000))
+			if err := mgr.run(); err != nil {
+				t.Fatal(err)
+			}
+
+			policy := k8sClient.objects["NetworkPolicy/default/pot-proxy"]
+			if policy == nil {
+				t.Fatalf("Expected NetworkPolicy to be created")
+			}
+			egress := policy.(*networkingv1.NetworkPolicy).Spec.Egress
+			if (len(egress) == 2) != tc.dns {
+				t.Errorf("Expected DNS egress %v, got %d rules", tc.dns, len(egress))
+			}
+			to := egress[0].To
+			switch {
+			case tc.selector:
+				if len(to) != 1 || to[0].PodSelector == nil || to[0].PodSelector.MatchLabels["app"] != "router" {
+					t.Fatalf("Expected router pods to be selected, got %v", to)
+				}
+				if egress[0].Ports[0].Port.StrVal != "amqp" {
+					t.Errorf("Expected router target port, got %v", egress[0].Ports[0].Port)
+				}
+			case tc.cidr != "":
+				if len(to) != 1 || to[0].IPBlock == nil || to[0].IPBlock.CIDR != tc.cidr {
+					t.Errorf("Expected egress to %s, got %v", tc.cidr, to)
+				}
+			default:
+				if len(to) != 0 {
+					t.Errorf("Expected egress to any destination, got %v", to)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyNetworkPolicyRefresh(t *testing.T) {
+	k8sClient := newFakeClient()
+	opt := newTestOptions()
+	opt.ProxyNetworkPolicy = true
+	source := &fakePortSource{}
+	mgr := newTestManager(opt, k8sClient, source)
+	source.set(newPublicPort("a", "tcp", 5000))
+	if err := mgr.reconcile(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Router Service appears without any change to the port set
+	routerSvc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "router", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "router"}},
+	}
+	if err := k8sClient.Create(context.TODO(), routerSvc); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	policy := k8sClient.objects["NetworkPolicy/default/pot-proxy"].(*networkingv1.NetworkPolicy)
+	if to := policy.Spec.Egress[0].To; len(to) != 1 || to[0].PodSelector == nil {
+		t.Errorf("Expected NetworkPolicy to follow the router, got %v", to)
+	}
+}
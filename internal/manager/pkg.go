@@ -26,6 +26,7 @@ var pkg struct {
 	controllerPort        int
 	managerName           string
 	pollInterval          time.Duration
+	stateSyncInterval     time.Duration
 }
 
 func init() {
@@ -33,4 +34,5 @@ func init() {
 	pkg.controllerPort = 51121
 	pkg.managerName = "port-manager"
 	pkg.pollInterval = time.Second * 10
+	pkg.stateSyncInterval = time.Minute
 }
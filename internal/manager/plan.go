@@ -0,0 +1,224 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	actionCreate   = "create"
+	actionUpdate   = "update"
+	actionDelete   = "delete"
+	actionRegister = "register"
+)
+
+// Change the manager would make to a Kubernetes resource or the Controller
+type Change struct {
+	Action string          `json:"action"`
+	Kind   string          `json:"kind"`
+	Name   string          `json:"name"`
+	Diff   string          `json:"diff,omitempty"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// Plan holds the changes a manager would make in a reconcile cycle when running in dry-run mode
+type Plan struct {
+	Proxy   string   `json:"proxy"`
+	Changes []Change `json:"changes"`
+}
+
+func newPlan(proxy string) *Plan {
+	return &Plan{
+		Proxy:   proxy,
+		Changes: make([]Change, 0),
+	}
+}
+
+// Human readable representation of the plan
+func (plan *Plan) String() string {
+	var builder strings.Builder
+	if len(plan.Changes) == 0 {
+		fmt.Fprintf(&builder, "%s: no changes\n", plan.Proxy)
+		return builder.String()
+	}
+	fmt.Fprintf(&builder, "%s: %d change(s)\n", plan.Proxy, len(plan.Changes))
+	symbols := map[string]string{
+		actionCreate:   "+",
+		actionUpdate:   "~",
+		actionDelete:   "-",
+		actionRegister: ">",
+	}
+	for _, change := range plan.Changes {
+		fmt.Fprintf(&builder, "%s %s %s %s\n", symbols[change.Action], change.Action, change.Kind, change.Name)
+		if change.Diff != "" {
+			for _, line := range strings.Split(strings.TrimSuffix(change.Diff, "\n"), "\n") {
+				fmt.Fprintf(&builder, "    %s\n", line)
+			}
+		}
+	}
+	return builder.String()
+}
+
+func (plan *Plan) record(change Change) {
+	plan.Changes = append(plan.Changes, change)
+}
+
+// Plan performs a single reconcile cycle without writing anything and returns the changes it would make
+// Manager must have been created with the DryRun option
+func (mgr *Manager) Plan() (*Plan, error) {
+	if !mgr.opt.DryRun {
+		return nil, fmt.Errorf("manager %s is not in dry-run mode", mgr.opt.ProxyName)
+	}
+	if err := mgr.start(); err != nil {
+		return mgr.plan, err
+	}
+	// Plan changes held back by the settle window as if it had elapsed
+	settleWindow, minInterval := mgr.opt.SettleWindow, mgr.opt.MinRolloutInterval
+	mgr.opt.SettleWindow, mgr.opt.MinRolloutInterval = 0, 0
+	defer func() {
+		mgr.opt.SettleWindow, mgr.opt.MinRolloutInterval = settleWindow, minInterval
+	}()
+	if err := mgr.run(); err != nil {
+		return mgr.plan, err
+	}
+	return mgr.plan, nil
+}
+
+// Report planned changes of the last cycle and start a new plan
+func (mgr *Manager) flushPlan() {
+	if mgr.plan == nil || len(mgr.plan.Changes) == 0 {
+		return
+	}
+	mgr.log.Info("Dry run, changes not applied\n" + mgr.plan.String())
+	mgr.plan = newPlan(mgr.opt.ProxyName)
+}
+
+func getKind(obj k8sclient.Object) string {
+	return reflect.TypeOf(obj).Elem().Name()
+}
+
+func (mgr *Manager) create(obj k8sclient.Object) error {
+	if mgr.opt.DryRun {
+		after, _ := json.MarshalIndent(obj, "", "  ")
+		mgr.plan.record(Change{
+			Action: actionCreate,
+			Kind:   getKind(obj),
+			Name:   obj.GetName(),
+			Diff:   diffLines("", string(after)),
+			After:  after,
+		})
+		return nil
+	}
+	return mgr.k8sClient.Create(context.TODO(), obj)
+}
+
+func (mgr *Manager) update(obj k8sclient.Object) error {
+	if mgr.opt.DryRun {
+		// Compare against the live object
+		live, ok := obj.DeepCopyObject().(k8sclient.Object)
+		if !ok {
+			return fmt.Errorf("could not copy %s %s", getKind(obj), obj.GetName())
+		}
+		if err := mgr.k8sClient.Get(context.TODO(), k8sclient.ObjectKeyFromObject(obj), live); err != nil {
+			return err
+		}
+		before, _ := json.MarshalIndent(live, "", "  ")
+		after, _ := json.MarshalIndent(obj, "", "  ")
+		mgr.plan.record(Change{
+			Action: actionUpdate,
+			Kind:   getKind(obj),
+			Name:   obj.GetName(),
+			Diff:   diffLines(string(before), string(after)),
+			Before: before,
+			After:  after,
+		})
+		return nil
+	}
+	return mgr.k8sClient.Update(context.TODO(), obj)
+}
+
+// Line based diff of two texts, unchanged lines are prefixed with a space
+func diffLines(before, after string) string {
+	var beforeLines, afterLines []string
+	if before != "" {
+		beforeLines = strings.Split(before, "\n")
+	}
+	if after != "" {
+		afterLines = strings.Split(after, "\n")
+	}
+
+	// Trim common prefix and suffix to keep the comparison small
+	prefix := 0
+	for prefix < len(beforeLines) && prefix < len(afterLines) && beforeLines[prefix] == afterLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(beforeLines)-prefix && suffix < len(afterLines)-prefix &&
+		beforeLines[len(beforeLines)-1-suffix] == afterLines[len(afterLines)-1-suffix] {
+		suffix++
+	}
+	if prefix == len(beforeLines) && prefix == len(afterLines) {
+		return ""
+	}
+	oldLines := beforeLines[prefix : len(beforeLines)-suffix]
+	newLines := afterLines[prefix : len(afterLines)-suffix]
+
+	// Longest common subsequence of the remaining lines
+	lcs := make([][]int, len(oldLines)+1)
+	for idx := range lcs {
+		lcs[idx] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var builder strings.Builder
+	contextLines := 2
+	for idx := max(0, prefix-contextLines); idx < prefix; idx++ {
+		fmt.Fprintf(&builder, "  %s\n", beforeLines[idx])
+	}
+	i, j := 0, 0
+	for i < len(oldLines) || j < len(newLines) {
+		switch {
+		case i < len(oldLines) && j < len(newLines) && oldLines[i] == newLines[j]:
+			fmt.Fprintf(&builder, "  %s\n", oldLines[i])
+			i++
+			j++
+		case i < len(oldLines) && (j == len(newLines) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&builder, "- %s\n", oldLines[i])
+			i++
+		default:
+			fmt.Fprintf(&builder, "+ %s\n", newLines[j])
+			j++
+		}
+	}
+	for idx := len(beforeLines) - suffix; idx < len(beforeLines)-suffix+contextLines && idx < len(beforeLines); idx++ {
+		fmt.Fprintf(&builder, "  %s\n", beforeLines[idx])
+	}
+	return builder.String()
+}
@@ -0,0 +1,112 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	if diff := diffLines("a\nb\nc", "a\nb\nc"); diff != "" {
+		t.Errorf("Expected empty diff, got %q", diff)
+	}
+	diff := diffLines("a\nb\nc\nd", "a\nx\nc\nd\ne")
+	expected := "  a\n- b\n+ x\n  c\n  d\n+ e\n"
+	if diff != expected {
+		t.Errorf("Unexpected diff:\n%s\nExpected:\n%s", diff, expected)
+	}
+	if diff := diffLines("", "a"); diff != "+ a\n" {
+		t.Errorf("Unexpected diff for creation: %q", diff)
+	}
+}
+
+func TestReconcileDryRun(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.DryRun = true
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, source)
+
+	source.set(newPublicPort("a", "tcp", 5000))
+	plan, err := mgr.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k8sClient.writes != 0 {
+		t.Errorf("Expected no writes in dry-run mode, got %d", k8sClient.writes)
+	}
+	if len(plan.Changes) != 3 {
+		t.Errorf("Expected Deployment, Service and registration changes, got %s", plan.String())
+	}
+}
+
+func TestReconcileDryRunCycles(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.DryRun = true
+	opt.DeletionGuardPercent = 50
+	k8sClient := newFakeClient()
+
+	// Live proxy serving three ports, the source only returns one
+	live := newTestManager(newTestOptions(), k8sClient, source)
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001), newPublicPort("c", "tcp", 5002))
+	if err := live.run(); err != nil {
+		t.Fatal(err)
+	}
+	dep, _ := getProxyObjects(t, live)
+	dep.Annotations = map[string]string{confirmRemovalAnnotation: "true"}
+	if err := k8sClient.Update(context.TODO(), dep); err != nil {
+		t.Fatal(err)
+	}
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001), newPublicPort("d", "tcp", 5003))
+
+	mgr := newTestManager(opt, k8sClient, source)
+	if err := mgr.start(); err != nil {
+		t.Fatal(err)
+	}
+	writes := k8sClient.writes
+	for cycle := 0; cycle < 2; cycle++ {
+		if err := mgr.run(); err != nil {
+			t.Fatal(err)
+		}
+		if len(mgr.plan.Changes) == 0 {
+			t.Errorf("Expected unapplied changes to be reported again in cycle %d", cycle)
+		}
+		if len(mgr.cache) != 3 {
+			t.Errorf("Expected dry run to keep the live ports, got %v", mgr.cache)
+		}
+		mgr.flushPlan()
+	}
+	if k8sClient.writes != writes {
+		t.Errorf("Expected no writes in dry-run mode, got %d", k8sClient.writes-writes)
+	}
+
+	// Removal of a third of the ports is below the guard, removing two thirds is not confirmed by a dry run
+	source.set(newPublicPort("a", "tcp", 5000))
+	for cycle := 0; cycle < 2; cycle++ {
+		if err := mgr.run(); err != nil {
+			t.Fatal(err)
+		}
+		for _, change := range mgr.plan.Changes {
+			if change.Kind == "Deployment" {
+				t.Errorf("Expected blocked removal not to be planned, got %s", mgr.plan.String())
+			}
+		}
+		mgr.flushPlan()
+	}
+	if dep, _ = getProxyObjects(t, live); dep.Annotations[confirmRemovalAnnotation] != "true" {
+		t.Errorf("Expected confirmation annotation to be kept by a dry run")
+	}
+}
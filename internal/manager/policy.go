@@ -0,0 +1,241 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+// PortPolicy restricts the public ports a proxy group exposes
+// Zero values impose no restriction
+type PortPolicy struct {
+	AllowedRanges           []PortRange `json:"allowedRanges,omitempty"`
+	DeniedRanges            []PortRange `json:"deniedRanges,omitempty"`
+	ReservedPorts           []int       `json:"reservedPorts,omitempty"`
+	MaxPortsPerApplication  int         `json:"maxPortsPerApplication,omitempty"`
+	MaxPortsPerMicroservice int         `json:"maxPortsPerMicroservice,omitempty"`
+}
+
+// PortRange is inclusive on both ends
+type PortRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+func (portRange PortRange) contains(port int) bool {
+	return port >= portRange.Start && port <= portRange.End
+}
+
+func (portRange PortRange) validate() error {
+	if portRange.Start < 1 || portRange.End > 65535 || portRange.Start > portRange.End {
+		return fmt.Errorf("invalid port range %d-%d", portRange.Start, portRange.End)
+	}
+	return nil
+}
+
+func checkPortPolicy(policy *PortPolicy) error {
+	for _, ranges := range [][]PortRange{policy.AllowedRanges, policy.DeniedRanges} {
+		for _, portRange := range ranges {
+			if err := portRange.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func inRanges(ranges []PortRange, port int) bool {
+	for _, portRange := range ranges {
+		if portRange.contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reason a port is not allowed by the range and reservation rules, empty if allowed
+func (policy *PortPolicy) check(port int) string {
+	if len(policy.AllowedRanges) != 0 && !inRanges(policy.AllowedRanges, port) {
+		return "outside of allowed ranges"
+	}
+	if inRanges(policy.DeniedRanges, port) {
+		return "in denied range"
+	}
+	for _, reserved := range policy.ReservedPorts {
+		if port == reserved {
+			return "reserved"
+		}
+	}
+	return ""
+}
+
+// Drop ports rejected by the policy before they enter the cache
+// Ports already exposed fill quotas first so that a new port never displaces one of them
+func (mgr *Manager) applyPortPolicy(ports []ioclient.MicroservicePublicPort) []ioclient.MicroservicePublicPort {
+	policy := &mgr.opt.PortPolicy
+	rejections := make(map[string]string)
+	reject := func(port *ioclient.MicroservicePublicPort, reason string) {
+		key := fmt.Sprintf("%s/%d", port.MicroserviceUUID, port.PublicPort.Port)
+		rejections[key] = fmt.Sprintf("Public port %d of microservice %s rejected: %s", port.PublicPort.Port, port.MicroserviceUUID, reason)
+	}
+
+	sorted := mgr.sortByPriority(ports)
+	accepted := make(map[ioclient.MicroservicePublicPort]bool)
+	perMicroservice := make(map[string]int)
+	perApplication := make(map[string]int)
+	for idx := range sorted {
+		port := &sorted[idx]
+		if reason := policy.check(port.PublicPort.Port); reason != "" {
+			reject(port, reason)
+			continue
+		}
+		if policy.MaxPortsPerMicroservice > 0 {
+			if perMicroservice[port.MicroserviceUUID] >= policy.MaxPortsPerMicroservice {
+				reject(port, fmt.Sprintf("microservice exceeds quota of %d ports", policy.MaxPortsPerMicroservice))
+				continue
+			}
+		}
+		if policy.MaxPortsPerApplication > 0 {
+			// Exposed ports are kept while their application cannot be looked up
+			application, err := mgr.getApplication(port.MicroserviceUUID)
+			if err != nil {
+				if !mgr.isCached(port) {
+					reject(port, err.Error())
+					continue
+				}
+				mgr.log.Error(err, "Failed to look up application, keeping exposed port", "port", port.PublicPort.Port)
+				perMicroservice[port.MicroserviceUUID]++
+				accepted[*port] = true
+				continue
+			}
+			if perApplication[application] >= policy.MaxPortsPerApplication {
+				reject(port, fmt.Sprintf("application %s exceeds quota of %d ports", application, policy.MaxPortsPerApplication))
+				continue
+			}
+			perApplication[application]++
+		}
+		perMicroservice[port.MicroserviceUUID]++
+		accepted[*port] = true
+	}
+
+	// Preserve the order of the source
+	allowed := make([]ioclient.MicroservicePublicPort, 0, len(accepted))
+	current := make(map[string]bool)
+	for _, port := range ports {
+		current[port.MicroserviceUUID] = true
+		if accepted[port] {
+			allowed = append(allowed, port)
+		}
+	}
+
+	allowed = mgr.checkExternalPorts(allowed, reject)
+
+	// Forget applications of removed microservices
+	for uuid := range mgr.applications {
+		if !current[uuid] {
+			delete(mgr.applications, uuid)
+			delete(mgr.microserviceNames, uuid)
+		}
+	}
+	mgr.reportRejections(rejections)
+	return allowed
+}
+
+// Ports already exposed come first, others follow in ascending port order so that the outcome does not depend on the order returned by the source
+func (mgr *Manager) sortByPriority(ports []ioclient.MicroservicePublicPort) []ioclient.MicroservicePublicPort {
+	sorted := make([]ioclient.MicroservicePublicPort, len(ports))
+	copy(sorted, ports)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		iCached, jCached := mgr.isCached(&sorted[i]), mgr.isCached(&sorted[j])
+		if iCached != jCached {
+			return iCached
+		}
+		return sorted[i].PublicPort.Port < sorted[j].PublicPort.Port
+	})
+	return sorted
+}
+
+func (mgr *Manager) isCached(port *ioclient.MicroservicePublicPort) bool {
+	cached, exists := mgr.cache[port.PublicPort.Port]
+	return exists && cached == port.PublicPort
+}
+
+// Application of a microservice, looked up once from the port source or the Controller
+func (mgr *Manager) getApplication(uuid string) (string, error) {
+	if application, exists := mgr.applications[uuid]; exists {
+		return application, nil
+	}
+	if err := mgr.lookupMicroservice(uuid); err != nil {
+		return "", err
+	}
+	return mgr.applications[uuid], nil
+}
+
+// Name of a microservice, looked up once from the port source or the Controller
+func (mgr *Manager) getMicroserviceName(uuid string) (string, error) {
+	if name, exists := mgr.microserviceNames[uuid]; exists {
+		return name, nil
+	}
+	if err := mgr.lookupMicroservice(uuid); err != nil {
+		return "", err
+	}
+	return mgr.microserviceNames[uuid], nil
+}
+
+func (mgr *Manager) lookupMicroservice(uuid string) error {
+	if src, ok := mgr.source.(microserviceDescriber); ok {
+		name, application, found := src.describeMicroservice(uuid)
+		if !found {
+			return fmt.Errorf("cannot find microservice %s in port source", uuid)
+		}
+		mgr.applications[uuid] = application
+		mgr.microserviceNames[uuid] = name
+		return nil
+	}
+	var msvc *ioclient.MicroserviceInfo
+	if err := mgr.callController(func(client *ioclient.Client) (err error) {
+		msvc, err = client.GetMicroserviceByID(uuid)
+		return
+	}); err != nil {
+		return fmt.Errorf("cannot find microservice %s: %s", uuid, err.Error())
+	}
+	mgr.applications[uuid] = msvc.Application
+	mgr.microserviceNames[uuid] = msvc.Name
+	return nil
+}
+
+// Log every rejection, only record Events and count metrics when a rejection is new
+func (mgr *Manager) reportRejections(rejections map[string]string) {
+	keys := make([]string, 0, len(rejections))
+	for key := range rejections {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		message := rejections[key]
+		mgr.log.Info(message)
+		if mgr.rejections[key] == message {
+			continue
+		}
+		metrics.rejectedPorts.Add(mgr.opt.ProxyName, 1)
+		mgr.recordEvent(corev1.EventTypeWarning, "PublicPortRejected", message)
+	}
+	mgr.rejections = rejections
+	setGauge(metrics.activeRejectedPorts, mgr.opt.ProxyName, int64(len(rejections)))
+}
@@ -0,0 +1,162 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"sort"
+	"testing"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+func TestReconcilePortPolicy(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.PortPolicy = PortPolicy{
+		AllowedRanges:           []PortRange{{Start: 1024, End: 65535}},
+		DeniedRanges:            []PortRange{{Start: 8000, End: 8099}},
+		ReservedPorts:           []int{9000},
+		MaxPortsPerMicroservice: 2,
+	}
+	mgr := newTestManager(opt, newFakeClient(), source)
+
+	source.set(
+		newPublicPort("a", "tcp", 80),
+		newPublicPort("a", "tcp", 8080),
+		newPublicPort("a", "tcp", 9000),
+		newPublicPort("b", "tcp", 7002),
+		newPublicPort("b", "tcp", 7001),
+		newPublicPort("b", "tcp", 7000),
+	)
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(mgr.cache) != 2 {
+		t.Errorf("Expected 2 ports in cache, got %v", mgr.cache)
+	}
+	for _, port := range []int{7000, 7001} {
+		if _, exists := mgr.cache[port]; !exists {
+			t.Errorf("Expected port %d within quota to be exposed", port)
+		}
+	}
+	if len(mgr.rejections) != 4 {
+		t.Errorf("Expected 4 rejections, got %v", mgr.rejections)
+	}
+}
+
+func TestReconcileApplicationQuota(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		exposed  []ioclient.MicroservicePublicPort // Ports of a first cycle
+		ports    []ioclient.MicroservicePublicPort
+		forget   string // Microservice whose application cannot be looked up after the first cycle
+		expected []int
+		rejected []string
+	}{
+		{
+			name:     "ascending",
+			ports:    []ioclient.MicroservicePublicPort{newPublicPort("b", "tcp", 5002), newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001), newPublicPort("c", "tcp", 5003)},
+			expected: []int{5000, 5001, 5003},
+			rejected: []string{"b/5002"},
+		},
+		{
+			name:     "exposed first",
+			exposed:  []ioclient.MicroservicePublicPort{newPublicPort("b", "tcp", 5002), newPublicPort("c", "tcp", 5003)},
+			ports:    []ioclient.MicroservicePublicPort{newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001), newPublicPort("b", "tcp", 5002), newPublicPort("c", "tcp", 5003)},
+			expected: []int{5000, 5002, 5003},
+			rejected: []string{"b/5001"},
+		},
+		{
+			name:     "unknown application",
+			ports:    []ioclient.MicroservicePublicPort{newPublicPort("a", "tcp", 5000), newPublicPort("x", "tcp", 6000)},
+			expected: []int{5000},
+			rejected: []string{"x/6000"},
+		},
+		{
+			name:     "exposed port of unknown application",
+			exposed:  []ioclient.MicroservicePublicPort{newPublicPort("c", "tcp", 5003)},
+			ports:    []ioclient.MicroservicePublicPort{newPublicPort("c", "tcp", 5003), newPublicPort("c", "tcp", 5004)},
+			forget:   "c",
+			expected: []int{5003},
+			rejected: []string{"c/5004"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			source := &fakePortSource{}
+			opt := newTestOptions()
+			opt.PortPolicy = PortPolicy{MaxPortsPerApplication: 2}
+			mgr := newTestManager(opt, newFakeClient(), source)
+			applications := map[string]string{"a": "shop", "b": "shop", "c": "blog"}
+			for uuid, application := range applications {
+				mgr.applications[uuid] = application
+			}
+
+			if test.exposed != nil {
+				source.set(test.exposed...)
+				if err := mgr.run(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Applications of microservices missing from the first cycle were forgotten
+			for uuid, application := range applications {
+				if uuid != test.forget {
+					mgr.applications[uuid] = application
+				} else {
+					delete(mgr.applications, uuid)
+				}
+			}
+			source.set(test.ports...)
+			if err := mgr.run(); err != nil {
+				t.Fatal(err)
+			}
+			exposed := make([]int, 0, len(mgr.cache))
+			for port := range mgr.cache {
+				exposed = append(exposed, port)
+			}
+			sort.Ints(exposed)
+			if len(exposed) != len(test.expected) {
+				t.Fatalf("Expected ports %v, got %v", test.expected, exposed)
+			}
+			for idx := range exposed {
+				if exposed[idx] != test.expected[idx] {
+					t.Errorf("Expected ports %v, got %v", test.expected, exposed)
+				}
+			}
+			if len(mgr.rejections) != len(test.rejected) {
+				t.Errorf("Expected rejections %v, got %v", test.rejected, mgr.rejections)
+			}
+			for _, key := range test.rejected {
+				if _, rejected := mgr.rejections[key]; !rejected {
+					t.Errorf("Expected %s to be rejected, got %v", key, mgr.rejections)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckPortPolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy PortPolicy
+		valid  bool
+	}{
+		{policy: PortPolicy{AllowedRanges: []PortRange{{Start: 1024, End: 65535}}}, valid: true},
+		{policy: PortPolicy{AllowedRanges: []PortRange{{Start: 2000, End: 1000}}}},
+		{policy: PortPolicy{DeniedRanges: []PortRange{{Start: 0, End: 1000}}}},
+		{policy: PortPolicy{DeniedRanges: []PortRange{{Start: 1000, End: 70000}}}},
+	} {
+		if err := checkPortPolicy(&test.policy); (err == nil) != test.valid {
+			t.Errorf("Policy %v: expected valid %v, got %v", test.policy, test.valid, err)
+		}
+	}
+}
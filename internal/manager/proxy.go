@@ -16,6 +16,8 @@ package manager
 import (
 	"errors"
 	"fmt"
+	"path"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -23,10 +25,18 @@ import (
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
+const (
+	routerTLSVolumeName     = "router-tls"
+	routerTLSMountPath      = "/etc/icproxy/tls"
+	routerTLSHashAnnotation = "datasance.com/router-tls-hash"
+	routerTLSCAKey          = "ca.crt"
+)
+
 func getProxyContainerArgs(config string) []string {
 	return []string{
 		"node",
@@ -35,7 +45,7 @@ func getProxyContainerArgs(config string) []string {
 	}
 }
 
-func newProxyDeployment(namespace, name, image, imagePullSecret string, replicas int32, config, routerHost, serverName, transport string) *appsv1.Deployment {
+func newProxyDeployment(namespace, name, image, imagePullSecret string, replicas int32, config string, router routerInfo) *appsv1.Deployment {
 	labels := map[string]string{
 		"name": name,
 	}
@@ -51,25 +61,75 @@ func newProxyDeployment(namespace, name, image, imagePullSecret string, replicas
 				Env: []corev1.EnvVar{
 					{
 						Name:  "ICPROXY_BRIDGE_HOST",
-						Value: routerHost,
+						Value: router.Host,
 					},
 				},
 			},
 		},
 	}
 
+	// Only set the port when it is known, the proxy has its own default
+	if router.Port != 0 {
+		podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, corev1.EnvVar{
+			Name:  "ICPROXY_BRIDGE_PORT",
+			Value: strconv.Itoa(router.Port),
+		})
+	}
+
 	// Check if serverName and transport are provided, and add corresponding EnvVars
-	if serverName != "" && transport != "" {
+	if router.ServerName != "" && router.Transport != "" {
 		podSpec.Containers[0].Env = append(podSpec.Containers[0].Env,
 			corev1.EnvVar{
 				Name:  "SERVERNAME",
-				Value: serverName,
+				Value: router.ServerName,
 			},
 			corev1.EnvVar{
 				Name:  "TRANSPORT",
-				Value: transport,
+				Value: router.Transport,
+			},
+		)
+	}
+
+	// Mount router TLS credentials, hash annotation rolls the proxy when the Secret rotates
+	var annotations map[string]string
+	if router.TLSSecret != "" {
+		// Mode is set explicitly as the API server defaults it and the Deployment would otherwise always differ
+		mode := corev1.SecretVolumeSourceDefaultMode
+		podSpec.Volumes = []corev1.Volume{
+			{
+				Name: routerTLSVolumeName,
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName:  router.TLSSecret,
+						DefaultMode: &mode,
+					},
+				},
+			},
+		}
+		podSpec.Containers[0].VolumeMounts = []corev1.VolumeMount{
+			{
+				Name:      routerTLSVolumeName,
+				MountPath: routerTLSMountPath,
+				ReadOnly:  true,
+			},
+		}
+		podSpec.Containers[0].Env = append(podSpec.Containers[0].Env,
+			corev1.EnvVar{
+				Name:  "ICPROXY_BRIDGE_CA",
+				Value: path.Join(routerTLSMountPath, routerTLSCAKey),
+			},
+			corev1.EnvVar{
+				Name:  "ICPROXY_BRIDGE_CERT",
+				Value: path.Join(routerTLSMountPath, corev1.TLSCertKey),
+			},
+			corev1.EnvVar{
+				Name:  "ICPROXY_BRIDGE_KEY",
+				Value: path.Join(routerTLSMountPath, corev1.TLSPrivateKeyKey),
 			},
 		)
+		annotations = map[string]string{
+			routerTLSHashAnnotation: router.TLSHash,
+		}
 	}
 
 	// If imagePullSecret is provided, add it to the ImagePullSecrets field
@@ -94,7 +154,8 @@ func newProxyDeployment(namespace, name, image, imagePullSecret string, replicas
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
+					Labels:      labels,
+					Annotations: annotations,
 				},
 				Spec: podSpec,
 			},
@@ -102,6 +163,146 @@ func newProxyDeployment(namespace, name, image, imagePullSecret string, replicas
 	}
 }
 
+// Bring an existing proxy Deployment in line with the desired one
+// Only fields owned by the manager are touched so that defaults set by the API Server do not cause endless updates
+func reconcileProxyDeployment(found, desired *appsv1.Deployment) (changed bool) {
+	// Labels
+	if found.Labels == nil {
+		found.Labels = make(map[string]string)
+	}
+	for key, value := range desired.Labels {
+		if found.Labels[key] != value {
+			found.Labels[key] = value
+			changed = true
+		}
+	}
+	template := &found.Spec.Template
+	if template.Labels == nil {
+		template.Labels = make(map[string]string)
+	}
+	for key, value := range desired.Spec.Template.Labels {
+		if template.Labels[key] != value {
+			template.Labels[key] = value
+			changed = true
+		}
+	}
+	// Annotations, only the TLS hash is owned by the manager
+	if hash := desired.Spec.Template.Annotations[routerTLSHashAnnotation]; template.Annotations[routerTLSHashAnnotation] != hash {
+		if hash == "" {
+			delete(template.Annotations, routerTLSHashAnnotation)
+		} else {
+			if template.Annotations == nil {
+				template.Annotations = make(map[string]string)
+			}
+			template.Annotations[routerTLSHashAnnotation] = hash
+		}
+		changed = true
+	}
+
+	// Pull secrets
+	desiredSpec := &desired.Spec.Template.Spec
+	if len(template.Spec.ImagePullSecrets) != 0 || len(desiredSpec.ImagePullSecrets) != 0 {
+		if !equality.Semantic.DeepEqual(template.Spec.ImagePullSecrets, desiredSpec.ImagePullSecrets) {
+			template.Spec.ImagePullSecrets = desiredSpec.ImagePullSecrets
+			changed = true
+		}
+	}
+
+	// Volumes
+	if len(template.Spec.Volumes) != 0 || len(desiredSpec.Volumes) != 0 {
+		if !equality.Semantic.DeepEqual(template.Spec.Volumes, desiredSpec.Volumes) {
+			template.Spec.Volumes = desiredSpec.Volumes
+			changed = true
+		}
+	}
+
+	// Container, replace entirely if it is not recognizable
+	if err := checkProxyDeployment(found); err != nil {
+		template.Spec.Containers = desiredSpec.Containers
+		return true
+	}
+	container := &template.Spec.Containers[0]
+	desiredContainer := &desiredSpec.Containers[0]
+	if container.Image != desiredContainer.Image {
+		container.Image = desiredContainer.Image
+		changed = true
+	}
+	if container.ImagePullPolicy != desiredContainer.ImagePullPolicy {
+		container.ImagePullPolicy = desiredContainer.ImagePullPolicy
+		changed = true
+	}
+	if !equality.Semantic.DeepEqual(container.Args, desiredContainer.Args) {
+		container.Args = desiredContainer.Args
+		changed = true
+	}
+	if !equality.Semantic.DeepEqual(container.Env, desiredContainer.Env) {
+		container.Env = desiredContainer.Env
+		changed = true
+	}
+	if len(container.Ports) != 0 || len(desiredContainer.Ports) != 0 {
+		if !equality.Semantic.DeepEqual(container.Ports, desiredContainer.Ports) {
+			container.Ports = desiredContainer.Ports
+			changed = true
+		}
+	}
+	if len(container.VolumeMounts) != 0 || len(desiredContainer.VolumeMounts) != 0 {
+		if !equality.Semantic.DeepEqual(container.VolumeMounts, desiredContainer.VolumeMounts) {
+			container.VolumeMounts = desiredContainer.VolumeMounts
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Bring an existing proxy Service in line with the desired one
+// Values allocated by the API Server, such as node ports, are kept
+func reconcileProxyService(found, desired *corev1.Service) (changed bool) {
+	// Annotations, only those configured are owned by the manager
+	for key, value := range desired.Annotations {
+		if found.Annotations[key] != value {
+			if found.Annotations == nil {
+				found.Annotations = make(map[string]string)
+			}
+			found.Annotations[key] = value
+			changed = true
+		}
+	}
+
+	// Selector, switched between colors by blue/green updates
+	if !equality.Semantic.DeepEqual(found.Spec.Selector, desired.Spec.Selector) {
+		found.Spec.Selector = desired.Spec.Selector
+		changed = true
+	}
+
+	// Ports, keeping allocated node ports
+	nodePorts := make(map[int32]int32)
+	for _, port := range found.Spec.Ports {
+		nodePorts[port.Port] = port.NodePort
+	}
+	ports := make([]corev1.ServicePort, len(desired.Spec.Ports))
+	for idx, port := range desired.Spec.Ports {
+		if port.NodePort == 0 {
+			port.NodePort = nodePorts[port.Port]
+		}
+		ports[idx] = port
+	}
+	if !equality.Semantic.DeepEqual(found.Spec.Ports, ports) {
+		found.Spec.Ports = ports
+		changed = true
+	}
+
+	// External IPs, only when configured
+	if len(desired.Spec.ExternalIPs) != 0 && !equality.Semantic.DeepEqual(found.Spec.ExternalIPs, desired.Spec.ExternalIPs) {
+		found.Spec.ExternalIPs = desired.Spec.ExternalIPs
+		changed = true
+	}
+	if desired.Spec.LoadBalancerIP != "" && found.Spec.LoadBalancerIP != desired.Spec.LoadBalancerIP {
+		found.Spec.LoadBalancerIP = desired.Spec.LoadBalancerIP
+		changed = true
+	}
+	return changed
+}
+
 func getRouterConfig(routerHost string) string { // nolint:unused,deadcode
 	config := `{
 	"scheme": "amqp",
@@ -110,7 +311,7 @@ func getRouterConfig(routerHost string) string { // nolint:unused,deadcode
 	return strings.Replace(config, "<ROUTER>", routerHost, 1)
 }
 
-func newProxyService(namespace, name string, ports portMap, svcType string, serviceAnnotations map[string]string) *corev1.Service {
+func newProxyService(namespace, name string, ports portMap, svcType string, serviceAnnotations map[string]string, externalIPs []string, mappings []PortMapping) *corev1.Service {
 	labels := map[string]string{
 		"name": name,
 	}
@@ -125,35 +326,51 @@ func newProxyService(namespace, name string, ports portMap, svcType string, serv
 			Type:                  corev1.ServiceType(svcType),
 			ExternalTrafficPolicy: getTrafficPolicy(svcType),
 			Selector:              labels,
+			ExternalIPs:           externalIPs,
 		},
 	}
-	modifyServiceSpec(svc, ports)
+	modifyServiceSpec(svc, ports, mappings)
 
 	return svc
 }
 
-func createProxyConfig(ports portMap) string {
-	config := ""
+// Ports ordered by number so that rendering is deterministic
+func sortPorts(ports portMap) []ioclient.PublicPort {
+	sorted := make([]ioclient.PublicPort, 0, len(ports))
 	for _, port := range ports {
-		separator := ","
-		if config == "" {
-			separator = ""
-		}
-		config = fmt.Sprintf("%s%s%s", config, separator, createProxyString(port))
+		sorted = append(sorted, port)
 	}
-	return config
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Port < sorted[j].Port
+	})
+	return sorted
 }
 
-func updateProxyConfig(dep *appsv1.Deployment, config string) error {
-	if err := checkProxyDeployment(dep); err != nil {
-		return err
+// Built in a single buffer, the config grows linearly with the number of ports
+func createProxyConfig(ports portMap, rules []ClientAddressRule) string {
+	var config strings.Builder
+	for idx, port := range sortPorts(ports) {
+		if idx != 0 {
+			config.WriteByte(',')
+		}
+		writeProxyString(&config, port)
+		writeClientAddressOptions(&config, getClientAddressRule(rules, port), port)
 	}
-	dep.Spec.Template.Spec.Containers[0].Args[len(getProxyContainerArgs(""))-1] = config
-	return nil
+	return config.String()
 }
 
 func createProxyString(port ioclient.PublicPort) string {
-	return fmt.Sprintf("%s:%d=>amqp:%s", port.Protocol, port.Port, port.Queue)
+	var config strings.Builder
+	writeProxyString(&config, port)
+	return config.String()
+}
+
+func writeProxyString(config *strings.Builder, port ioclient.PublicPort) {
+	config.WriteString(port.Protocol)
+	config.WriteByte(':')
+	config.WriteString(strconv.Itoa(port.Port))
+	config.WriteString("=>amqp:")
+	config.WriteString(port.Queue)
 }
 
 func getProxyConfig(dep *appsv1.Deployment) (string, error) {
@@ -190,6 +407,19 @@ func decodeConfig(config, startDelim, endDelim string) (ports map[int]bool, err
 	return
 }
 
+// Decode every item of a proxy config
+func decodeProxyConfig(config string) ([]ioclient.PublicPort, error) {
+	ports := make([]ioclient.PublicPort, 0)
+	for _, configItem := range strings.Split(config, ",") {
+		port, err := decodeMicroservice(configItem)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, *port)
+	}
+	return ports, nil
+}
+
 func decodeMicroservice(configItem string) (*ioclient.PublicPort, error) {
 	// {protocol}:{msvcPort}=>amqp:{queueName}
 	// Protocol
@@ -211,7 +441,8 @@ func decodeMicroservice(configItem string) (*ioclient.PublicPort, error) {
 	if len(ids) != 2 {
 		return nil, errors.New("Could not split after =>amqp: in config item " + configItem)
 	}
-	queue := ids[1]
+	// Options follow the queue name
+	queue, _, _ := strings.Cut(ids[1], proxyOptionSeparator)
 	return &ioclient.PublicPort{
 		Protocol: protocol,
 		Queue:    queue,
@@ -219,10 +450,11 @@ func decodeMicroservice(configItem string) (*ioclient.PublicPort, error) {
 	}, nil
 }
 
-func generateServicePort(port int, queue string) corev1.ServicePort {
+// Service port forwarding the external port to the port the proxy listens on
+func generateServicePort(port, externalPort int, queue string) corev1.ServicePort {
 	return corev1.ServicePort{
 		Name:       strings.ToLower(queue),
-		Port:       int32(port),
+		Port:       int32(externalPort),
 		TargetPort: intstr.FromInt(port),
 		Protocol:   corev1.Protocol("TCP"),
 	}
@@ -235,9 +467,9 @@ func getTrafficPolicy(serviceType string) corev1.ServiceExternalTrafficPolicyTyp
 	return ""
 }
 
-func modifyServiceSpec(svc *corev1.Service, ports portMap) {
+func modifyServiceSpec(svc *corev1.Service, ports portMap, mappings []PortMapping) {
 	svc.Spec.Ports = make([]corev1.ServicePort, 0)
-	for _, port := range ports {
-		svc.Spec.Ports = append(svc.Spec.Ports, generateServicePort(port.Port, port.Queue))
+	for _, port := range sortPorts(ports) {
+		svc.Spec.Ports = append(svc.Spec.Ports, generateServicePort(port.Port, getExternalPort(mappings, port), port.Queue))
 	}
 }
@@ -0,0 +1,214 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+func TestReconcileProxyDeployment(t *testing.T) {
+	found := newProxyDeployment("ns", "proxy", "proxy:1", "", 1, "tcp:5000=>amqp:q", routerInfo{Host: "router"})
+	desired := newProxyDeployment("ns", "proxy", "proxy:1", "", 1, "tcp:5000=>amqp:q", routerInfo{Host: "router"})
+	if reconcileProxyDeployment(found, desired) {
+		t.Errorf("Expected no change for identical Deployments")
+	}
+
+	desired = newProxyDeployment("ns", "proxy", "proxy:2", "secret", 1, "tcp:5000=>amqp:q", routerInfo{Host: "router2", ServerName: "router2", Transport: "tls"})
+	if !reconcileProxyDeployment(found, desired) {
+		t.Fatalf("Expected change for drifted Deployment")
+	}
+	container := found.Spec.Template.Spec.Containers[0]
+	if container.Image != "proxy:2" {
+		t.Errorf("Image was not reconciled: %s", container.Image)
+	}
+	if len(container.Env) != 3 || container.Env[0].Value != "router2" {
+		t.Errorf("Env was not reconciled: %v", container.Env)
+	}
+	if len(found.Spec.Template.Spec.ImagePullSecrets) != 1 {
+		t.Errorf("Pull secrets were not reconciled")
+	}
+	if reconcileProxyDeployment(found, desired) {
+		t.Errorf("Expected no change after reconciliation")
+	}
+}
+
+func TestRouterTLSRotation(t *testing.T) {
+	router := routerInfo{Host: "router", TLSSecret: "router-tls", TLSHash: "a"}
+	withTLSDefaults(&router)
+	found := newProxyDeployment("ns", "proxy", "proxy:1", "", 1, "tcp:5000=>amqp:q", router)
+	podSpec := found.Spec.Template.Spec
+	if len(podSpec.Volumes) != 1 || len(podSpec.Containers[0].VolumeMounts) != 1 {
+		t.Fatalf("Expected router TLS Secret to be mounted")
+	}
+	if router.Transport != "tls" || router.ServerName != "router" {
+		t.Errorf("Expected TLS transport defaults, got %v", router)
+	}
+
+	router.TLSHash = "b"
+	desired := newProxyDeployment("ns", "proxy", "proxy:1", "", 1, "tcp:5000=>amqp:q", router)
+	if !reconcileProxyDeployment(found, desired) {
+		t.Fatalf("Expected rotated Secret to change the Deployment")
+	}
+	if found.Spec.Template.Annotations[routerTLSHashAnnotation] != "b" {
+		t.Errorf("TLS hash annotation was not updated")
+	}
+}
+
+func TestReconcileServerDefaults(t *testing.T) {
+	cases := []struct {
+		name  string
+		apply func(opt *Options)
+	}{
+		{"plain", func(opt *Options) {}},
+		{"router TLS", func(opt *Options) { opt.RouterTLSSecret = "router-tls" }},
+		{"config file", func(opt *Options) {
+			opt.ProxyConfigStorage = ConfigStorageConfigMap
+			opt.ProxyFeatures = []string{ProxyFeatureConfigFile}
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			k8sClient := newFakeClient()
+			k8sClient.defaults = true
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "router-tls", Namespace: "default"}}
+			if err := k8sClient.Create(context.TODO(), secret); err != nil {
+				t.Fatal(err)
+			}
+			opt := newTestOptions()
+			tc.apply(opt)
+			source := &fakePortSource{}
+			mgr := newTestManager(opt, k8sClient, source)
+			source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "http", 5001))
+			if err := mgr.run(); err != nil {
+				t.Fatal(err)
+			}
+			writes := k8sClient.writes
+			if err := mgr.run(); err != nil {
+				t.Fatal(err)
+			}
+			if err := mgr.updateProxy(); err != nil {
+				t.Fatal(err)
+			}
+			if k8sClient.writes != writes {
+				t.Errorf("Expected no writes against defaulted objects, got %d", k8sClient.writes-writes)
+			}
+		})
+	}
+}
+
+func TestRouterFailureKeepsRouter(t *testing.T) {
+	k8sClient := newFakeClient()
+	opt := newTestOptions()
+	opt.RouterTLSSecret = "router-tls"
+	source := &fakePortSource{}
+	mgr := newTestManager(opt, k8sClient, source)
+	mgr.router.TLSHash = "a"
+
+	// Secret cannot be read, ports are still reconciled with the mounted credentials
+	source.set(newPublicPort("a", "tcp", 5000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(mgr.cache) != 1 || mgr.router.TLSHash != "a" {
+		t.Errorf("Expected cache to be reconciled with the last known router, got %d ports and hash %q", len(mgr.cache), mgr.router.TLSHash)
+	}
+	events := k8sClient.count("Event")
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if k8sClient.count("Event") != events {
+		t.Errorf("Expected a repeated failure to be recorded once")
+	}
+}
+
+func TestHashSecretData(t *testing.T) {
+	first := hashSecretData(map[string][]byte{"a": []byte("bc")})
+	second := hashSecretData(map[string][]byte{"ab": []byte("c")})
+	if first == second {
+		t.Errorf("Expected entries to be delimited in the hash")
+	}
+}
+
+func TestProxyRenderingOrder(t *testing.T) {
+	ports := make(portMap)
+	for _, port := range []int{5003, 80, 5001, 443, 5002} {
+		ports[port] = ioclient.PublicPort{Protocol: "tcp", Port: port, Queue: fmt.Sprintf("q%d", port)}
+	}
+	expected := "tcp:80=>amqp:q80,tcp:443=>amqp:q443,tcp:5001=>amqp:q5001,tcp:5002=>amqp:q5002,tcp:5003=>amqp:q5003"
+	for idx := 0; idx < 10; idx++ {
+		if config := createProxyConfig(ports, nil); config != expected {
+			t.Fatalf("Expected config %s, got %s", expected, config)
+		}
+	}
+	svc := newProxyService("ns", "proxy", ports, "LoadBalancer", nil, nil, nil)
+	for idx := 1; idx < len(svc.Spec.Ports); idx++ {
+		if svc.Spec.Ports[idx-1].Port > svc.Spec.Ports[idx].Port {
+			t.Fatalf("Expected Service ports in order, got %v", svc.Spec.Ports)
+		}
+	}
+}
+
+func TestReconcileProxyService(t *testing.T) {
+	ports := portMap{5000: {Protocol: "tcp", Port: 5000, Queue: "a"}}
+	found := newProxyService("ns", "proxy", ports, "LoadBalancer", nil, nil, nil)
+	// Node port allocated by the API Server
+	found.Spec.Ports[0].NodePort = 30000
+	if reconcileProxyService(found, newProxyService("ns", "proxy", ports, "LoadBalancer", nil, nil, nil)) {
+		t.Errorf("Expected no change for identical ports")
+	}
+
+	ports[5001] = ioclient.PublicPort{Protocol: "tcp", Port: 5001, Queue: "b"}
+	desired := newProxyService("ns", "proxy", ports, "LoadBalancer", map[string]string{"key": "value"}, nil, nil)
+	if !reconcileProxyService(found, desired) {
+		t.Fatalf("Expected change for new port")
+	}
+	if len(found.Spec.Ports) != 2 || found.Spec.Ports[0].NodePort != 30000 || found.Annotations["key"] != "value" {
+		t.Errorf("Service was not reconciled: %v", found)
+	}
+}
+
+func TestProxyClientAddress(t *testing.T) {
+	rules := []ClientAddressRule{
+		{PortSelector: PortSelector{Protocol: "tcp", Range: &PortRange{Start: 6000, End: 6999}}, AcceptProxyProtocol: ProxyProtocolV2, ForwardClientAddress: true},
+		{PortSelector: PortSelector{Port: 5000}, ForwardClientAddress: true},
+		{PortSelector: PortSelector{Port: 7001}, ForwardClientAddress: true},
+	}
+	ports := portMap{
+		5000: {Protocol: "http", Port: 5000, Queue: "a"},
+		6001: {Protocol: "tcp", Port: 6001, Queue: "b"},
+		7000: {Protocol: "tcp", Port: 7000, Queue: "c"},
+		7001: {Protocol: "tcp", Port: 7001, Queue: "d"},
+	}
+	expected := "http:5000=>amqp:a;forward-client=x-forwarded-for,tcp:6001=>amqp:b;accept-proxy=v2;forward-client=v2,tcp:7000=>amqp:c,tcp:7001=>amqp:d;forward-client=v1"
+	config := createProxyConfig(ports, rules)
+	if config != expected {
+		t.Fatalf("Expected config %s, got %s", expected, config)
+	}
+	decoded, err := decodeProxyConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, port := range decoded {
+		if port != ports[port.Port] {
+			t.Errorf("Expected decoded port %v, got %v", ports[port.Port], port)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func getProxyObjects(t *testing.T, mgr *Manager) (*appsv1.Deployment, *corev1.Service) {
+	t.Helper()
+	key := k8sclient.ObjectKey{Name: mgr.opt.ProxyName, Namespace: mgr.opt.Namespace}
+	dep := &appsv1.Deployment{}
+	if err := mgr.k8sClient.Get(context.TODO(), key, dep); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		dep = nil
+	}
+	svc := &corev1.Service{}
+	if err := mgr.k8sClient.Get(context.TODO(), key, svc); err != nil {
+		if !k8serrors.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		svc = nil
+	}
+	return dep, svc
+}
+
+func TestReconcileLifecycle(t *testing.T) {
+	source := &fakePortSource{}
+	mgr := newTestManager(newTestOptions(), newFakeClient(), source)
+
+	// Create
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "http", 6000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	dep, svc := getProxyObjects(t, mgr)
+	if dep == nil || svc == nil {
+		t.Fatalf("Expected Proxy Deployment and Service to be created")
+	}
+	config, err := getProxyConfig(dep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(config, "tcp:5000=>amqp:a") || !strings.Contains(config, "http:6000=>amqp:b") {
+		t.Errorf("Unexpected Proxy config %s", config)
+	}
+	if len(svc.Spec.Ports) != 2 {
+		t.Errorf("Expected 2 Service ports, got %d", len(svc.Spec.Ports))
+	}
+
+	// Queue change and removal
+	source.set(newPublicPort("c", "tcp", 5000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	dep, svc = getProxyObjects(t, mgr)
+	if config, _ := getProxyConfig(dep); config != "tcp:5000=>amqp:c" {
+		t.Errorf("Unexpected Proxy config %s", config)
+	}
+	if len(svc.Spec.Ports) != 1 {
+		t.Errorf("Expected 1 Service port, got %d", len(svc.Spec.Ports))
+	}
+
+	// Cache is rebuilt from the Deployment after a restart
+	restarted := newTestManager(newTestOptions(), mgr.k8sClient.(*fakeClient), source)
+	if err := restarted.generateCache(); err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.cache) != 1 || restarted.cache[5000].Queue != "c" {
+		t.Errorf("Unexpected cache after restart %v", restarted.cache)
+	}
+
+	// Delete
+	source.set()
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if dep, svc = getProxyObjects(t, mgr); dep != nil || svc != nil {
+		t.Errorf("Expected Proxy Deployment and Service to be deleted")
+	}
+}
+
+func TestReconcileProtocolFilter(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.ProtocolFilter = "http"
+	mgr := newTestManager(opt, newFakeClient(), source)
+
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "http", 6000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(mgr.cache) != 1 {
+		t.Fatalf("Expected only HTTP port in cache, got %v", mgr.cache)
+	}
+	if _, exists := mgr.cache[6000]; !exists {
+		t.Errorf("Expected HTTP port 6000 in cache")
+	}
+}
+
+func TestReconcileNoop(t *testing.T) {
+	source := &fakePortSource{}
+	k8sClient := newFakeClient()
+	mgr := newTestManager(newTestOptions(), k8sClient, source)
+
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001), newPublicPort("c", "http", 5002))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	writes := k8sClient.writes
+	for idx := 0; idx < 3; idx++ {
+		if err := mgr.updateProxy(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if k8sClient.writes != writes {
+		t.Errorf("Expected no writes when nothing changed, got %d", k8sClient.writes-writes)
+	}
+}
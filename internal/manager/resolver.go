@@ -0,0 +1,220 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Strategies to resolve the externally reachable address of the proxy
+const (
+	AddressResolverLoadBalancer = "loadbalancer"
+	AddressResolverNodePort     = "nodeport"
+	AddressResolverClusterIP    = "clusterip"
+	AddressResolverExternalIP   = "externalip"
+	AddressResolverStatic       = "static"
+)
+
+// Resolve the address to register with the Controller, empty while it is not available yet
+// Service is nil when the proxy Service does not exist
+type addressResolver interface {
+	resolve(svc *corev1.Service) (string, error)
+}
+
+// Resolver selected by the Options, nil if the strategy is unknown
+func newAddressResolver(mgr *Manager) addressResolver {
+	switch mgr.opt.AddressResolver {
+	case AddressResolverLoadBalancer:
+		return &loadBalancerResolver{preferHostname: mgr.opt.AddressPreferHostname}
+	case AddressResolverNodePort:
+		addressType := corev1.NodeAddressType(mgr.opt.NodeAddressType)
+		if addressType == "" {
+			addressType = corev1.NodeExternalIP
+		}
+		return &nodePortResolver{
+			mgr:         mgr,
+			selector:    mgr.opt.NodeSelector,
+			addressType: addressType,
+		}
+	case AddressResolverClusterIP:
+		return &clusterIPResolver{}
+	case AddressResolverExternalIP:
+		return &externalIPResolver{}
+	case AddressResolverStatic:
+		return &staticResolver{address: mgr.opt.ProxyExternalAddress}
+	}
+	return nil
+}
+
+// Default strategy when none is configured
+func getDefaultAddressResolver(opt *Options) string {
+	if opt.ProxyExternalAddress != "" {
+		return AddressResolverStatic
+	}
+	switch corev1.ServiceType(opt.ProxyServiceType) {
+	case corev1.ServiceTypeNodePort:
+		return AddressResolverNodePort
+	case corev1.ServiceTypeClusterIP:
+		return AddressResolverClusterIP
+	}
+	return AddressResolverLoadBalancer
+}
+
+// Service type required by a strategy, empty if any type will do
+func getResolverServiceType(resolver string) corev1.ServiceType {
+	switch resolver {
+	case AddressResolverLoadBalancer:
+		return corev1.ServiceTypeLoadBalancer
+	case AddressResolverNodePort:
+		return corev1.ServiceTypeNodePort
+	case AddressResolverClusterIP:
+		return corev1.ServiceTypeClusterIP
+	}
+	return ""
+}
+
+// Ingress of a LoadBalancer Service, IP or hostname depending on preference
+type loadBalancerResolver struct {
+	preferHostname bool
+}
+
+func (resolver *loadBalancerResolver) resolve(svc *corev1.Service) (string, error) {
+	if svc == nil {
+		return "", nil
+	}
+	ip, hostname := "", ""
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ip == "" {
+			ip = ingress.IP
+		}
+		if hostname == "" {
+			hostname = ingress.Hostname
+		}
+	}
+	if resolver.preferHostname && hostname != "" || ip == "" {
+		return hostname, nil
+	}
+	return ip, nil
+}
+
+// Address of a Ready node matching the selector, nodes are ordered by name so the choice is stable
+// Ports are exposed on node ports equal to their external ports, see pinNodePorts
+type nodePortResolver struct {
+	mgr         *Manager
+	selector    map[string]string
+	addressType corev1.NodeAddressType
+}
+
+func (resolver *nodePortResolver) resolve(svc *corev1.Service) (string, error) {
+	if svc == nil {
+		return "", nil
+	}
+	nodes := corev1.NodeList{}
+	if err := resolver.mgr.k8sClient.List(context.TODO(), &nodes, k8sclient.MatchingLabels(resolver.selector)); err != nil {
+		return "", err
+	}
+	sort.Slice(nodes.Items, func(i, j int) bool {
+		return nodes.Items[i].Name < nodes.Items[j].Name
+	})
+	for idx := range nodes.Items {
+		node := &nodes.Items[idx]
+		if !isNodeReady(node) {
+			continue
+		}
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == resolver.addressType && addr.Address != "" {
+				return addr.Address, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// Cluster IP of the Service, for consumers inside the cluster
+type clusterIPResolver struct{}
+
+func (resolver *clusterIPResolver) resolve(svc *corev1.Service) (string, error) {
+	if svc == nil || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		return "", nil
+	}
+	return svc.Spec.ClusterIP, nil
+}
+
+// First external IP assigned to the Service
+type externalIPResolver struct{}
+
+func (resolver *externalIPResolver) resolve(svc *corev1.Service) (string, error) {
+	if svc == nil || len(svc.Spec.ExternalIPs) == 0 {
+		return "", nil
+	}
+	return svc.Spec.ExternalIPs[0], nil
+}
+
+// Fixed address or DNS name, e.g. of an Ingress or an external load balancer
+type staticResolver struct {
+	address string
+}
+
+func (resolver *staticResolver) resolve(*corev1.Service) (string, error) {
+	return resolver.address, nil
+}
+
+// The Controller holds a single proxy address, so further Services must be reachable at the same address
+// Node ports are unique in the cluster and external IPs are assigned to every Service, load balancers need a shared IP
+func (mgr *Manager) checkSharedAddress(feature string) error {
+	switch mgr.opt.AddressResolver {
+	case AddressResolverNodePort, AddressResolverExternalIP:
+		return nil
+	case AddressResolverClusterIP:
+		return fmt.Errorf("%s cannot share the cluster IP of a Service", feature)
+	case AddressResolverLoadBalancer:
+		if mgr.opt.ProxyLoadBalancerIP == "" {
+			return fmt.Errorf("%s require a shared load balancer IP", feature)
+		}
+		return nil
+	}
+	if len(mgr.opt.ProxyExternalIPs) == 0 && mgr.opt.ProxyLoadBalancerIP == "" {
+		return fmt.Errorf("%s require a shared external IP or load balancer IP", feature)
+	}
+	return nil
+}
+
+// The registered node address is only reachable on the external port of each public port,
+// so the nodeport strategy pins node ports instead of leaving them to the API Server
+func (mgr *Manager) pinsNodePorts() bool {
+	return mgr.opt.AddressResolver == AddressResolverNodePort
+}
+
+func (mgr *Manager) pinNodePorts(svc *corev1.Service) {
+	if !mgr.pinsNodePorts() {
+		return
+	}
+	for idx := range svc.Spec.Ports {
+		svc.Spec.Ports[idx].NodePort = svc.Spec.Ports[idx].Port
+	}
+}
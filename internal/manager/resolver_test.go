@@ -0,0 +1,146 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestNode(name, role string, ready bool, addresses ...corev1.NodeAddress) *corev1.Node {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"role": role},
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}},
+			Addresses:  addresses,
+		},
+	}
+}
+
+func TestAddressResolvers(t *testing.T) {
+	k8sClient := newFakeClient()
+	for _, node := range []*corev1.Node{
+		newTestNode("a", "edge", false, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "10.0.0.1"}),
+		newTestNode("b", "edge", true, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "192.168.0.2"}, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "10.0.0.2"}),
+		newTestNode("c", "edge", true, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "10.0.0.3"}),
+		newTestNode("d", "worker", true, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "10.0.0.4"}),
+	} {
+		if err := k8sClient.Create(context.TODO(), node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &corev1.Service{
+		Spec: corev1.ServiceSpec{
+			ClusterIP:   "172.16.0.1",
+			ExternalIPs: []string{"8.8.8.8"},
+		},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{Hostname: "lb.example.com"}, {IP: "1.1.1.1"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(opt *Options)
+		svcType  corev1.ServiceType
+		expected string
+	}{
+		{"default", func(opt *Options) {}, corev1.ServiceTypeLoadBalancer, "1.1.1.1"},
+		{"hostname", func(opt *Options) { opt.AddressPreferHostname = true }, corev1.ServiceTypeLoadBalancer, "lb.example.com"},
+		{"static", func(opt *Options) { opt.ProxyExternalAddress = "proxy.example.com" }, corev1.ServiceTypeLoadBalancer, "proxy.example.com"},
+		{"clusterip", func(opt *Options) { opt.ProxyServiceType = "ClusterIP" }, corev1.ServiceTypeClusterIP, "172.16.0.1"},
+		{"externalip", func(opt *Options) { opt.AddressResolver = AddressResolverExternalIP }, corev1.ServiceTypeLoadBalancer, "8.8.8.8"},
+		{"nodeport", func(opt *Options) {
+			opt.AddressResolver = AddressResolverNodePort
+			opt.ProxyServiceType = ""
+		}, corev1.ServiceTypeNodePort, "10.0.0.2"},
+		{"internal", func(opt *Options) {
+			opt.AddressResolver = AddressResolverNodePort
+			opt.ProxyServiceType = ""
+			opt.NodeAddressType = string(corev1.NodeInternalIP)
+		}, corev1.ServiceTypeNodePort, "192.168.0.2"},
+		{"selector", func(opt *Options) {
+			opt.AddressResolver = AddressResolverNodePort
+			opt.ProxyServiceType = ""
+			opt.NodeSelector = map[string]string{"role": "worker"}
+		}, corev1.ServiceTypeNodePort, "10.0.0.4"},
+	}
+	for _, test := range tests {
+		opt := newTestOptions()
+		test.modify(opt)
+		mgr := newTestManager(opt, k8sClient, &fakePortSource{})
+		if mgr.opt.ProxyServiceType != string(test.svcType) {
+			t.Errorf("%s: expected Service type %s, got %s", test.name, test.svcType, mgr.opt.ProxyServiceType)
+		}
+		addr, err := mgr.resolver.resolve(svc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != test.expected {
+			t.Errorf("%s: expected address %s, got %s", test.name, test.expected, addr)
+		}
+	}
+
+	// Only the static strategy resolves without a Service
+	opt := newTestOptions()
+	mgr := newTestManager(opt, k8sClient, &fakePortSource{})
+	if addr, _ := mgr.resolver.resolve(nil); addr != "" {
+		t.Errorf("Expected no address without a Service, got %s", addr)
+	}
+	opt = newTestOptions()
+	opt.AddressResolver = "unknown"
+	if mgr = newTestManager(opt, k8sClient, &fakePortSource{}); mgr.resolver != nil {
+		t.Errorf("Expected unknown address resolver to be rejected")
+	}
+}
+
+func TestReconcileNodePorts(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.AddressResolver = AddressResolverNodePort
+	opt.ProxyServiceType = ""
+	opt.PortMappings = []PortMapping{{PortSelector: PortSelector{Port: 5001}, ExternalPort: 30001}}
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, source)
+
+	// Node ports equal the external ports, ports outside the node port range are rejected
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001), newPublicPort("c", "tcp", 30080))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	_, svc := getProxyObjects(t, mgr)
+	nodePorts := make(map[int32]int32)
+	for _, port := range svc.Spec.Ports {
+		nodePorts[port.TargetPort.IntVal] = port.NodePort
+	}
+	if len(nodePorts) != 2 || nodePorts[5001] != 30001 || nodePorts[30080] != 30080 {
+		t.Errorf("Expected node ports 30001 and 30080, got %v", svc.Spec.Ports)
+	}
+	if events := getEvents(k8sClient, "PublicPortRejected"); len(events) != 1 || !strings.Contains(events[0].Message, "node port range") {
+		t.Errorf("Expected public port 5000 to be rejected, got %v", events)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+// Items of the work queue
+const (
+	reconcileItem = "reconcile"
+	registerItem  = "register"
+	linksItem     = "links"
+)
+
+// Exponential backoff with jitter, MaxRetries of 0 retries forever
+type RetryPolicy struct {
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	Jitter     float64 // Fraction of the delay randomly added to it
+	MaxRetries int
+}
+
+func withRetryDefaults(policy RetryPolicy) RetryPolicy {
+	if policy.BaseDelay == 0 {
+		policy.BaseDelay = 5 * time.Second
+	}
+	if policy.MaxDelay == 0 {
+		policy.MaxDelay = 5 * time.Minute
+	}
+	if policy.Jitter == 0 {
+		policy.Jitter = 0.2
+	}
+	return policy
+}
+
+// Rate limiter applying the retry policy of each item
+type jitterRateLimiter struct {
+	limiters map[string]workqueue.TypedRateLimiter[string]
+	jitter   map[string]float64
+}
+
+func newJitterRateLimiter(policies map[string]RetryPolicy) *jitterRateLimiter {
+	limiter := &jitterRateLimiter{
+		limiters: make(map[string]workqueue.TypedRateLimiter[string]),
+		jitter:   make(map[string]float64),
+	}
+	for item, policy := range policies {
+		limiter.limiters[item] = workqueue.NewTypedItemExponentialFailureRateLimiter[string](policy.BaseDelay, policy.MaxDelay)
+		limiter.jitter[item] = policy.Jitter
+	}
+	return limiter
+}
+
+func (limiter *jitterRateLimiter) When(item string) time.Duration {
+	delay := limiter.limiters[item].When(item)
+	return delay + time.Duration(rand.Float64()*limiter.jitter[item]*float64(delay)) // nolint:gosec
+}
+
+func (limiter *jitterRateLimiter) Forget(item string) {
+	limiter.limiters[item].Forget(item)
+}
+
+func (limiter *jitterRateLimiter) NumRequeues(item string) int {
+	return limiter.limiters[item].NumRequeues(item)
+}
+
+var errCircuitOpen = errors.New("circuit breaker for Controller API is open")
+
+// Stops calling the Controller after threshold consecutive failures
+// After the cooldown a single call is let through, its outcome closes or reopens the circuit
+type circuitBreaker struct {
+	threshold int // 0 disables the breaker
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+}
+
+func (breaker *circuitBreaker) isOpen() bool {
+	return breaker.threshold > 0 && breaker.failures >= breaker.threshold && time.Since(breaker.openedAt) < breaker.cooldown
+}
+
+// Returns true when the circuit has just opened
+func (breaker *circuitBreaker) record(err error) bool {
+	if err == nil {
+		breaker.failures = 0
+		return false
+	}
+	breaker.failures++
+	if breaker.threshold > 0 && breaker.failures >= breaker.threshold {
+		breaker.openedAt = time.Now()
+		return breaker.failures == breaker.threshold
+	}
+	return false
+}
+
+// Call the Controller API through the circuit breaker
+func (mgr *Manager) callController(call func(client *ioclient.Client) error) error {
+	if mgr.ioClient == nil {
+		return errors.New("not logged into Controller API")
+	}
+	if mgr.breaker.isOpen() {
+		return errCircuitOpen
+	}
+	err := call(mgr.ioClient)
+	if mgr.breaker.record(err) {
+		msg := fmt.Sprintf("Controller API failed %d times in a row, pausing calls for %s: %s", mgr.breaker.failures, mgr.breaker.cooldown, err.Error())
+		mgr.log.Info(msg)
+		mgr.recordEvent(corev1.EventTypeWarning, "ControllerCircuitOpen", msg)
+		metrics.circuitBreakerTrips.Add(mgr.opt.ProxyName, 1)
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+func TestJitterRateLimiter(t *testing.T) {
+	limiter := newJitterRateLimiter(map[string]RetryPolicy{
+		reconcileItem: {BaseDelay: time.Second, MaxDelay: 4 * time.Second, Jitter: 0.5},
+	})
+	for idx, base := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		delay := limiter.When(reconcileItem)
+		if delay < base || delay > base+base/2 {
+			t.Errorf("Retry %d: expected delay between %s and %s, got %s", idx, base, base+base/2, delay)
+		}
+	}
+	limiter.Forget(reconcileItem)
+	if limiter.NumRequeues(reconcileItem) != 0 {
+		t.Errorf("Expected retries to be forgotten")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	opt := newTestOptions()
+	opt.CircuitBreakerThreshold = 2
+	opt.CircuitBreakerCooldown = time.Hour
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, &fakePortSource{})
+	mgr.ioClient = &ioclient.Client{}
+
+	calls := 0
+	fail := func(*ioclient.Client) error {
+		calls++
+		return errors.New("refused")
+	}
+	for idx := 0; idx < 3; idx++ {
+		_ = mgr.callController(fail)
+	}
+	if calls != 2 {
+		t.Errorf("Expected calls to stop after 2 failures, got %d calls", calls)
+	}
+	if err := mgr.callController(fail); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("Expected open circuit, got %v", err)
+	}
+	if k8sClient.count("Event") != 1 {
+		t.Errorf("Expected one Event when the circuit opens, got %d", k8sClient.count("Event"))
+	}
+
+	// After the cooldown one call is let through and closes the circuit on success
+	mgr.breaker.openedAt = time.Now().Add(-2 * time.Hour)
+	if err := mgr.callController(func(*ioclient.Client) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.breaker.isOpen() || mgr.breaker.failures != 0 {
+		t.Errorf("Expected circuit to close after a successful call")
+	}
+}
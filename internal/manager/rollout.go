@@ -0,0 +1,205 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Revision of the desired template last written to the proxy Deployment, read back by a restarted manager
+const revisionAnnotation = "datasance.com/proxy-revision"
+
+// Progress of the latest proxy Deployment change
+// Known-good template is persisted with the state, otherwise a restarted manager takes the template of a completed rollout
+type rolloutTracker struct {
+	revision       string // Revision being rolled out, empty when none is in progress
+	started        time.Time
+	generation     int64
+	goodTemplate   *corev1.PodTemplateSpec // Template of the last rollout that completed
+	goodRevision   string
+	failedRevision string // Revision that failed, it is applied again once retryAt passes
+	failures       int    // Consecutive failures of failedRevision
+	retryAt        time.Time
+}
+
+// Failed revisions are retried after the deadline, doubled with each failure up to an hour
+func (rollout *rolloutTracker) fail(revision string, deadline time.Duration) {
+	if rollout.failedRevision != revision {
+		rollout.failures = 0
+	}
+	rollout.failedRevision = revision
+	rollout.failures++
+	backoff := time.Hour
+	if rollout.failures <= 10 {
+		backoff = deadline << (rollout.failures - 1)
+	}
+	if backoff > time.Hour {
+		backoff = time.Hour
+	}
+	rollout.retryAt = time.Now().Add(backoff)
+}
+
+// Whether a revision failed and its backoff has not passed yet
+func (rollout *rolloutTracker) isHeldBack(revision string) bool {
+	return revision == rollout.failedRevision && time.Now().Before(rollout.retryAt)
+}
+
+// Identify a desired proxy template
+func getRevision(template *corev1.PodTemplateSpec) string {
+	data, _ := json.Marshal(template)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+func setRevision(dep *appsv1.Deployment, revision string) {
+	if dep.Annotations == nil {
+		dep.Annotations = make(map[string]string)
+	}
+	dep.Annotations[revisionAnnotation] = revision
+}
+
+// Resume tracking after a restart, a completed rollout is known-good and one in progress is given a new deadline
+func (mgr *Manager) restoreRollout(dep *appsv1.Deployment) {
+	if mgr.opt.RolloutDeadline < 0 {
+		return
+	}
+	revision := dep.Annotations[revisionAnnotation]
+	if isRolloutComplete(dep, dep.Generation) {
+		mgr.rollout.goodTemplate = dep.Spec.Template.DeepCopy()
+		mgr.rollout.goodRevision = revision
+		return
+	}
+	mgr.beginRollout(dep, revision)
+}
+
+// Start tracking a Deployment that was just written
+func (mgr *Manager) beginRollout(dep *appsv1.Deployment, revision string) {
+	if mgr.opt.DryRun || mgr.opt.RolloutDeadline < 0 {
+		return
+	}
+	mgr.rollout.revision = revision
+	mgr.rollout.started = time.Now()
+	mgr.rollout.generation = dep.Generation
+}
+
+func isRolloutComplete(dep *appsv1.Deployment, generation int64) bool {
+	replicas := int32(1)
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+	status := dep.Status
+	return status.ObservedGeneration >= generation &&
+		status.UpdatedReplicas == replicas &&
+		status.Replicas == replicas &&
+		status.AvailableReplicas == replicas
+}
+
+func isRolloutStuck(dep *appsv1.Deployment) bool {
+	for _, condition := range dep.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing {
+			return condition.Status == corev1.ConditionFalse && condition.Reason == "ProgressDeadlineExceeded"
+		}
+	}
+	return false
+}
+
+// Check the rollout in progress, rolling back to the last known-good template when it misses the deadline
+func (mgr *Manager) checkRollout() error {
+	if mgr.rollout.revision == "" {
+		return nil
+	}
+	dep := appsv1.Deployment{}
+	proxyKey := k8sclient.ObjectKey{
+		Name:      mgr.getProxyDeploymentName(),
+		Namespace: mgr.opt.Namespace,
+	}
+	if err := mgr.k8sClient.Get(context.TODO(), proxyKey, &dep); err != nil {
+		if k8serrors.IsNotFound(err) {
+			mgr.rollout.revision = ""
+			return nil
+		}
+		return err
+	}
+
+	if isRolloutComplete(&dep, mgr.rollout.generation) {
+		mgr.log.Info("Proxy rollout completed", "revision", mgr.rollout.revision, "duration", time.Since(mgr.rollout.started).String())
+		mgr.rollout.goodTemplate = dep.Spec.Template.DeepCopy()
+		mgr.rollout.goodRevision = mgr.rollout.revision
+		mgr.rollout.revision = ""
+		mgr.rollout.failedRevision = ""
+		mgr.rollout.failures = 0
+		metrics.rolloutsCompleted.Add(mgr.opt.ProxyName, 1)
+		setGauge(metrics.rolloutFailing, mgr.opt.ProxyName, 0)
+		return nil
+	}
+	if time.Since(mgr.rollout.started) < mgr.opt.RolloutDeadline && !isRolloutStuck(&dep) {
+		return nil
+	}
+
+	// Rollout failed
+	failed := mgr.rollout.revision
+	mgr.rollout.revision = ""
+	mgr.rollout.fail(failed, mgr.opt.RolloutDeadline)
+	metrics.rolloutsFailed.Add(mgr.opt.ProxyName, 1)
+	setGauge(metrics.rolloutFailing, mgr.opt.ProxyName, 1)
+	msg := fmt.Sprintf("Proxy revision %s did not become ready within %s", failed, mgr.opt.RolloutDeadline)
+	if mgr.rollout.goodTemplate == nil {
+		msg += ", no known-good revision to roll back to"
+		mgr.log.Info(msg)
+		mgr.recordEvent(corev1.EventTypeWarning, "ProxyRolloutFailed", msg)
+		return nil
+	}
+	good := mgr.rollout.goodTemplate.DeepCopy()
+	msg += fmt.Sprintf(", rolling back to revision %s and retrying after %s", mgr.rollout.goodRevision, mgr.rollout.retryAt.Format(time.RFC3339))
+	mgr.log.Info(msg)
+	mgr.recordEvent(corev1.EventTypeWarning, "ProxyRolloutFailed", msg)
+
+	dep.Spec.Template = *good
+	setRevision(&dep, mgr.rollout.goodRevision)
+	if err := mgr.update(&dep); err != nil {
+		return fmt.Errorf("could not roll back Proxy: %s", err.Error())
+	}
+	mgr.beginRollout(&dep, mgr.rollout.goodRevision)
+	metrics.rollbacks.Add(mgr.opt.ProxyName, 1)
+	return mgr.revertCache(&dep)
+}
+
+// Cache the ports served by a Deployment, so that Services and registrations match the proxy while a revision is held back
+func (mgr *Manager) revertCache(dep *appsv1.Deployment) error {
+	config, err := mgr.readProxyConfig(dep)
+	if err != nil {
+		return err
+	}
+	ports, err := decodeProxyConfig(config)
+	if err != nil {
+		return err
+	}
+	cache := make(portMap, len(ports))
+	for _, port := range ports {
+		cache[port.Port] = port
+	}
+	mgr.cache = cache
+	mgr.assignShards()
+	return nil
+}
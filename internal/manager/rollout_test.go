@@ -0,0 +1,157 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+func TestReconcileRollback(t *testing.T) {
+	source := &fakePortSource{}
+	k8sClient := newFakeClient()
+	mgr := newTestManager(newTestOptions(), k8sClient, source)
+	setStatus := func(available int32) {
+		dep, _ := getProxyObjects(t, mgr)
+		dep.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: available}
+		if err := k8sClient.Update(context.TODO(), dep); err != nil {
+			t.Fatal(err)
+		}
+	}
+	getConfig := func() string {
+		dep, _ := getProxyObjects(t, mgr)
+		config, _ := getProxyConfig(dep)
+		return config
+	}
+
+	source.set(newPublicPort("a", "tcp", 5000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	setStatus(1)
+	if err := mgr.checkRollout(); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.rollout.goodTemplate == nil {
+		t.Fatalf("Expected completed rollout to be known-good")
+	}
+
+	// New revision never becomes available
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	setStatus(0)
+	if err := mgr.checkRollout(); err != nil {
+		t.Fatal(err)
+	}
+	if getConfig() != "tcp:5000=>amqp:a,tcp:5001=>amqp:b" {
+		t.Fatalf("Expected no rollback before the deadline, got %s", getConfig())
+	}
+	mgr.rollout.started = time.Now().Add(-time.Hour)
+	if err := mgr.checkRollout(); err != nil {
+		t.Fatal(err)
+	}
+	if getConfig() != "tcp:5000=>amqp:a" || k8sClient.count("Event") != 1 {
+		t.Fatalf("Expected rollback with an Event, got %s", getConfig())
+	}
+
+	// Failed revision is held back, the cache and Service follow the proxy
+	if len(mgr.cache) != 1 {
+		t.Errorf("Expected cache to be reverted, got %v", mgr.cache)
+	}
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, svc := getProxyObjects(t, mgr); getConfig() != "tcp:5000=>amqp:a" || len(svc.Spec.Ports) != 1 {
+		t.Errorf("Expected failed revision not to be reapplied, got %s", getConfig())
+	}
+
+	// Failed revision is retried after the backoff
+	mgr.rollout.retryAt = time.Now()
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if getConfig() != "tcp:5000=>amqp:a,tcp:5001=>amqp:b" {
+		t.Errorf("Expected failed revision to be retried, got %s", getConfig())
+	}
+
+	// New revision is applied at once
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("c", "tcp", 5002))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if getConfig() != "tcp:5000=>amqp:a,tcp:5002=>amqp:c" {
+		t.Errorf("Expected new revision to be applied, got %s", getConfig())
+	}
+}
+
+func TestRolloutRestart(t *testing.T) {
+	testCases := []struct {
+		name         string
+		persistState bool
+		inProgress   bool
+	}{
+		{name: "completed rollout"},
+		{name: "rollout in progress", persistState: true, inProgress: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := &fakePortSource{}
+			opt := newTestOptions()
+			opt.PersistState = tc.persistState
+			k8sClient := newFakeClient()
+			mgr := newTestManager(opt, k8sClient, source)
+			setStatus := func(available int32) {
+				dep, _ := getProxyObjects(t, mgr)
+				dep.Status = appsv1.DeploymentStatus{ObservedGeneration: dep.Generation, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: available}
+				if err := k8sClient.Update(context.TODO(), dep); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			source.set(newPublicPort("a", "tcp", 5000))
+			if err := mgr.run(); err != nil {
+				t.Fatal(err)
+			}
+			setStatus(1)
+			if err := mgr.checkRollout(); err != nil {
+				t.Fatal(err)
+			}
+			good := mgr.rollout.goodRevision
+			mgr.persistState()
+			if tc.inProgress {
+				source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001))
+				if err := mgr.run(); err != nil {
+					t.Fatal(err)
+				}
+				setStatus(0)
+			}
+
+			restarted := newTestManager(opt, k8sClient, source)
+			if err := restarted.generateCache(); err != nil {
+				t.Fatal(err)
+			}
+			if restarted.rollout.goodTemplate == nil || restarted.rollout.goodRevision != good {
+				t.Errorf("Expected known-good revision %s, got %q", good, restarted.rollout.goodRevision)
+			}
+			if inProgress := restarted.rollout.revision != ""; inProgress != tc.inProgress {
+				t.Errorf("Expected rollout in progress %v, got revision %q", tc.inProgress, restarted.rollout.revision)
+			}
+		})
+	}
+}
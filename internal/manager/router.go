@@ -0,0 +1,176 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+// Details the proxy requires to connect to the router
+type routerInfo struct {
+	Host       string
+	Port       int
+	ServerName string
+	Transport  string
+	TLSSecret  string
+	TLSHash    string
+}
+
+func newStaticRouterInfo(opt *Options) routerInfo {
+	info := routerInfo{
+		Host:       opt.RouterAddress,
+		ServerName: opt.RouterServerName,
+		Transport:  opt.RouterTransport,
+		TLSSecret:  opt.RouterTLSSecret,
+	}
+	withTLSDefaults(&info)
+	return info
+}
+
+// Mounted credentials imply a TLS connection to the router
+func withTLSDefaults(info *routerInfo) {
+	if info.TLSSecret == "" {
+		return
+	}
+	if info.Transport == "" {
+		info.Transport = "tls"
+	}
+	if info.ServerName == "" {
+		info.ServerName = info.Host
+	}
+}
+
+// Derive router connection details from the Controller's default router
+// Static Options take precedence for TLS settings when provided
+func newDiscoveredRouterInfo(opt *Options, router *ioclient.Router) routerInfo {
+	info := routerInfo{
+		Host: router.Host,
+	}
+	if router.MessagingPort != nil {
+		info.Port = *router.MessagingPort
+	}
+	if router.RequireSsl != nil && *router.RequireSsl == "true" {
+		info.ServerName = router.Host
+		info.Transport = "tls"
+		if opt.RouterServerName != "" {
+			info.ServerName = opt.RouterServerName
+		}
+		if opt.RouterTransport != "" {
+			info.Transport = opt.RouterTransport
+		}
+	}
+	info.TLSSecret = opt.RouterTLSSecret
+	withTLSDefaults(&info)
+	return info
+}
+
+// Refresh the router details before reconciling the proxy
+// Failures keep the last known details so that public ports are still reconciled
+func (mgr *Manager) refreshRouter() (changed bool) {
+	if mgr.opt.RouterDiscovery {
+		discovered, err := mgr.discoverRouter()
+		mgr.reportRouterError("RouterDiscoveryFailed", "Failed to discover default router", err)
+		changed = discovered
+	}
+	rotated, err := mgr.refreshRouterTLS()
+	mgr.reportRouterError("RouterTLSSecretUnavailable", "Failed to read router TLS Secret", err)
+	return changed || rotated
+}
+
+// Log each failure, an Event is recorded when the failure first appears or changes
+func (mgr *Manager) reportRouterError(reason, msg string, err error) {
+	if err == nil {
+		delete(mgr.routerErrors, reason)
+		return
+	}
+	mgr.log.Error(err, msg)
+	if mgr.routerErrors[reason] == err.Error() {
+		return
+	}
+	mgr.routerErrors[reason] = err.Error()
+	mgr.recordEvent(corev1.EventTypeWarning, reason, fmt.Sprintf("%s, keeping last known details: %s", msg, err.Error()))
+}
+
+// Query the Controller for the default router and store its details
+// Returns true when the router details differ from those previously known
+func (mgr *Manager) discoverRouter() (changed bool, err error) {
+	var router ioclient.Router
+	if err = mgr.callController(func(client *ioclient.Client) (err error) {
+		router, err = client.GetDefaultRouter()
+		return
+	}); err != nil {
+		return false, err
+	}
+	if router.Host == "" {
+		return false, errors.New("default router returned by Controller has no host")
+	}
+	info := newDiscoveredRouterInfo(mgr.opt, &router)
+	info.TLSHash = mgr.router.TLSHash
+	if info == mgr.router {
+		return false, nil
+	}
+	mgr.log.Info("Discovered default router", "host", info.Host, "port", info.Port, "transport", info.Transport)
+	mgr.router = info
+	return true, nil
+}
+
+// Hash the router TLS Secret so that rotations can be detected
+// Returns true when the hash differs from the one previously known
+func (mgr *Manager) refreshRouterTLS() (changed bool, err error) {
+	if mgr.opt.RouterTLSSecret == "" {
+		return false, nil
+	}
+	secretKey := k8sclient.ObjectKey{
+		Name:      mgr.opt.RouterTLSSecret,
+		Namespace: mgr.opt.Namespace,
+	}
+	secret := corev1.Secret{}
+	if err := mgr.k8sClient.Get(context.TODO(), secretKey, &secret); err != nil {
+		return false, err
+	}
+	hash := hashSecretData(secret.Data)
+	if hash == mgr.router.TLSHash {
+		return false, nil
+	}
+	if mgr.router.TLSHash != "" {
+		mgr.log.Info("Router TLS Secret changed", "secret", mgr.opt.RouterTLSSecret)
+	}
+	mgr.router.TLSHash = hash
+	return true, nil
+}
+
+func hashSecretData(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// Keys and lengths delimit each entry so that moving bytes between them changes the hash
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s\x00%d\x00", key, len(data[key]))
+		hash.Write(data[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
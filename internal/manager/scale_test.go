@@ -0,0 +1,195 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const scalePortCount = 10000
+
+func newScalePorts(count, offset int) []ioclient.MicroservicePublicPort {
+	ports := make([]ioclient.MicroservicePublicPort, 0, count)
+	for idx := 0; idx < count; idx++ {
+		uuid := fmt.Sprintf("microservice-%05d-%d", idx, offset)
+		ports = append(ports, newPublicPort(uuid, "tcp", 10000+idx))
+	}
+	return ports
+}
+
+func newScalePortMap(count int) portMap {
+	ports := make(portMap)
+	for _, port := range newScalePorts(count, 0) {
+		ports[port.PublicPort.Port] = port.PublicPort
+	}
+	return ports
+}
+
+func TestReconcileConfigMapStorage(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, source)
+
+	// Too many ports for a container argument
+	source.set(newScalePorts(scalePortCount, 0)...)
+	if err := mgr.run(); err == nil || !strings.Contains(err.Error(), "argument limit") {
+		t.Fatalf("Expected argument limit error, got %v", err)
+	}
+
+	opt.ProxyConfigStorage = ConfigStorageConfigMap
+	opt.ProxyFeatures = []string{ProxyFeatureConfigFile}
+	mgr = newTestManager(opt, k8sClient, source)
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	dep, _ := getProxyObjects(t, mgr)
+	if config, _ := getProxyConfig(dep); config != "@/etc/icproxy/config/proxy.conf" {
+		t.Fatalf("Expected config file reference, got %s", config)
+	}
+	firstConfig := getProxyConfigVolume(&dep.Spec.Template)
+	configMap := corev1.ConfigMap{}
+	if err := k8sClient.Get(context.TODO(), k8sclient.ObjectKey{Name: firstConfig, Namespace: opt.Namespace}, &configMap); err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Data[proxyConfigKey] != mgr.renderProxyConfig(mgr.cache) {
+		t.Errorf("Expected ConfigMap to hold the proxy config")
+	}
+
+	// Restarted manager reads the config from the ConfigMap
+	restarted := newTestManager(opt, k8sClient, source)
+	if err := restarted.generateCache(); err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.cache) != scalePortCount {
+		t.Fatalf("Expected %d cached ports, got %d", scalePortCount, len(restarted.cache))
+	}
+
+	// Changed config is written to a new ConfigMap, the unused one is pruned
+	source.set(newScalePorts(scalePortCount-1, 0)...)
+	if err := restarted.run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.pruneProxyConfigs(); err != nil {
+		t.Fatal(err)
+	}
+	dep, _ = getProxyObjects(t, restarted)
+	if name := getProxyConfigVolume(&dep.Spec.Template); name == firstConfig {
+		t.Errorf("Expected new ConfigMap for changed config")
+	}
+	if count := k8sClient.count("ConfigMap"); count != 1 {
+		t.Errorf("Expected unused ConfigMap to be pruned, found %d", count)
+	}
+}
+
+func TestPersistStateSize(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.ProxyConfigStorage = ConfigStorageConfigMap
+	opt.ProxyFeatures = []string{ProxyFeatureConfigFile}
+	opt.PersistState = true
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, source)
+
+	source.set(newScalePorts(scalePortCount, 0)...)
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	mgr.persistState()
+	configMap := corev1.ConfigMap{}
+	if err := k8sClient.Get(context.TODO(), k8sclient.ObjectKey{Name: mgr.getStateName(), Namespace: opt.Namespace}, &configMap); err != nil {
+		t.Fatal(err)
+	}
+	if size := getStateSize(configMap.Data, configMap.BinaryData); size > maxConfigMapSize/2 {
+		t.Errorf("Expected state of %d ports to take at most half of the ConfigMap limit, got %d bytes", scalePortCount, size)
+	}
+
+	// Restarted manager serves the whole response
+	restarted := newTestManager(opt, k8sClient, source)
+	if err := restarted.restoreState(); err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.state.hasResponse || len(restarted.state.response) != scalePortCount {
+		t.Errorf("Expected %d restored ports, got %d", scalePortCount, len(restarted.state.response))
+	}
+}
+
+func BenchmarkCreateProxyConfig(b *testing.B) {
+	ports := newScalePortMap(scalePortCount)
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		createProxyConfig(ports, nil)
+	}
+}
+
+func BenchmarkGenerateCache(b *testing.B) {
+	opt := newTestOptions()
+	opt.ProxyConfigStorage = ConfigStorageConfigMap
+	opt.ProxyFeatures = []string{ProxyFeatureConfigFile}
+	k8sClient := newFakeClient()
+	source := &fakePortSource{ports: newScalePorts(scalePortCount, 0)}
+	if err := newTestManager(opt, k8sClient, source).run(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		if err := newTestManager(opt, k8sClient, source).generateCache(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Diffing the source against the cache, alternating between two sets of queues so that every cycle changes every port
+func BenchmarkRun(b *testing.B) {
+	opt := newTestOptions()
+	opt.ProxyConfigStorage = ConfigStorageConfigMap
+	opt.ProxyFeatures = []string{ProxyFeatureConfigFile}
+	k8sClient := newFakeClient()
+	source := &fakePortSource{}
+	mgr := newTestManager(opt, k8sClient, source)
+	responses := [][]ioclient.MicroservicePublicPort{newScalePorts(scalePortCount, 0), newScalePorts(scalePortCount, 1)}
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		source.set(responses[idx%2]...)
+		if err := mgr.run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Diffing an unchanged source, the common case
+func BenchmarkRunNoop(b *testing.B) {
+	opt := newTestOptions()
+	opt.ProxyConfigStorage = ConfigStorageConfigMap
+	opt.ProxyFeatures = []string{ProxyFeatureConfigFile}
+	k8sClient := newFakeClient()
+	source := &fakePortSource{ports: newScalePorts(scalePortCount, 0)}
+	mgr := newTestManager(opt, k8sClient, source)
+	if err := mgr.run(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		if err := mgr.run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const shardLabel = "datasance.com/proxy-shard"
+
+func (mgr *Manager) isSharded() bool {
+	return mgr.opt.MaxServicePorts > 0
+}
+
+// First shard keeps the proxy name so that unsharded proxies carry over
+func (mgr *Manager) getShardName(shard int) string {
+	if shard == 0 {
+		return mgr.opt.ProxyName
+	}
+	return mgr.opt.ProxyName + "-shard-" + strconv.Itoa(shard)
+}
+
+// Assign cached ports to shards once per cycle, ports keep their shard unless it is over the limit
+// New ports fill the lowest shard with room in port order, so assignment only depends on the existing shards
+func (mgr *Manager) assignShards() {
+	shards := make(map[int]portMap)
+	ports := mgr.getServicePorts()
+	if !mgr.isSharded() {
+		shards[0] = ports
+		mgr.shardPorts = shards
+		return
+	}
+
+	assignments := make(map[int]int, len(ports))
+	unassigned := make([]int, 0)
+	for _, port := range sortPorts(ports) {
+		shard, assigned := mgr.shards[port.Port]
+		if !assigned || len(shards[shard]) >= mgr.opt.MaxServicePorts {
+			unassigned = append(unassigned, port.Port)
+			continue
+		}
+		if shards[shard] == nil {
+			shards[shard] = make(portMap)
+		}
+		shards[shard][port.Port] = port
+		assignments[port.Port] = shard
+	}
+	shard := 0
+	for _, port := range unassigned {
+		for len(shards[shard]) >= mgr.opt.MaxServicePorts {
+			shard++
+		}
+		if shards[shard] == nil {
+			shards[shard] = make(portMap)
+		}
+		shards[shard][port] = ports[port]
+		assignments[port] = shard
+	}
+	fillFirstShard(shards, assignments)
+	mgr.shards = assignments
+	mgr.shardPorts = shards
+}
+
+// The first Service carries the registered address, so it takes over a port of the last shard
+// rather than being deleted while other shards still serve ports
+func fillFirstShard(shards map[int]portMap, assignments map[int]int) {
+	indexes := getShardIndexes(shards)
+	if len(shards[0]) != 0 || len(indexes) == 0 {
+		return
+	}
+	last := indexes[len(indexes)-1]
+	port := sortPorts(shards[last])[0]
+	shards[0] = portMap{port.Port: port}
+	delete(shards[last], port.Port)
+	if len(shards[last]) == 0 {
+		delete(shards, last)
+	}
+	assignments[port.Port] = 0
+}
+
+// Ports served by a shard Service
+func (mgr *Manager) getShardPorts(shard int) portMap {
+	return mgr.shardPorts[shard]
+}
+
+// Read the shard of each port from the existing Services
+func (mgr *Manager) restoreShards() error {
+	if !mgr.isSharded() {
+		return nil
+	}
+	svcs, err := mgr.listShardServices()
+	if err != nil {
+		return err
+	}
+	for idx := range svcs.Items {
+		shard, err := mgr.getServiceShard(&svcs.Items[idx])
+		if err != nil {
+			continue
+		}
+		for _, port := range svcs.Items[idx].Spec.Ports {
+			mgr.shards[port.TargetPort.IntValue()] = shard
+		}
+	}
+	return nil
+}
+
+// Shard of a proxy Service, the first shard may predate sharding and lack the label
+func (mgr *Manager) getServiceShard(svc *corev1.Service) (int, error) {
+	if svc.Name == mgr.opt.ProxyName {
+		return 0, nil
+	}
+	return strconv.Atoi(svc.Labels[shardLabel])
+}
+
+func (mgr *Manager) listShardServices() (*corev1.ServiceList, error) {
+	svcs := corev1.ServiceList{}
+	if err := mgr.k8sClient.List(context.TODO(), &svcs, k8sclient.InNamespace(mgr.opt.Namespace), k8sclient.MatchingLabels{"name": mgr.opt.ProxyName}); err != nil {
+		return nil, err
+	}
+	items := make([]corev1.Service, 0, len(svcs.Items))
+	for idx := range svcs.Items {
+		if _, exists := svcs.Items[idx].Labels[shardLabel]; exists || svcs.Items[idx].Name == mgr.opt.ProxyName {
+			items = append(items, svcs.Items[idx])
+		}
+	}
+	svcs.Items = items
+	return &svcs, nil
+}
+
+// Desired Service of a shard, selecting the same proxy pods as the first shard
+func (mgr *Manager) newShardService(shard int, ports portMap) *corev1.Service {
+	svc := mgr.newProxyService()
+	svc.Name = mgr.getShardName(shard)
+	svc.Labels = map[string]string{
+		"name":     mgr.opt.ProxyName,
+		shardLabel: strconv.Itoa(shard),
+	}
+	modifyServiceSpec(svc, ports, mgr.opt.PortMappings)
+	mgr.pinNodePorts(svc)
+	mgr.setDNSAnnotations(svc, ports, false)
+	return svc
+}
+
+// Create, update and delete the Services of all shards after the first
+func (mgr *Manager) updateShardServices() error {
+	if !mgr.isSharded() {
+		return nil
+	}
+	shards := mgr.shardPorts
+	for _, shard := range getShardIndexes(shards) {
+		if shard == 0 {
+			continue
+		}
+		ports := shards[shard]
+		desired := mgr.newShardService(shard, ports)
+		found := corev1.Service{}
+		err := mgr.k8sClient.Get(context.TODO(), k8sclient.ObjectKeyFromObject(desired), &found)
+		switch {
+		case k8serrors.IsNotFound(err):
+			mgr.setOwnerReference(desired)
+			err = mgr.create(desired)
+		case err == nil && reconcileProxyService(&found, desired):
+			err = mgr.update(&found)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Remove shards without ports
+	svcs, err := mgr.listShardServices()
+	if err != nil {
+		return err
+	}
+	for idx := range svcs.Items {
+		svc := &svcs.Items[idx]
+		shard, err := mgr.getServiceShard(svc)
+		if err != nil || shard == 0 || len(shards[shard]) != 0 {
+			continue
+		}
+		if err := mgr.delete(svc); err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+		delete(mgr.shardAddresses, svc.Name)
+	}
+	return nil
+}
+
+func getShardIndexes(shards map[int]portMap) []int {
+	indexes := make([]int, 0, len(shards))
+	for shard := range shards {
+		indexes = append(indexes, shard)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// Names of all proxy Services in shard order
+func (mgr *Manager) getProxyServiceNames() []string {
+	shards := mgr.shardPorts
+	names := make([]string, 0, len(shards))
+	for _, shard := range getShardIndexes(shards) {
+		names = append(names, mgr.getShardName(shard))
+	}
+	if mgr.isVirtualHosting() {
+		names = append(names, mgr.getVirtualHostName())
+	}
+	return names
+}
+
+// Resolve the address of each additional shard, the Controller only holds the address of the first Service
+// so ports of a shard resolving elsewhere are unreachable and reported through Events
+func (mgr *Manager) trackShardAddresses() error {
+	if !mgr.isSharded() {
+		return nil
+	}
+	registered := mgr.state.address
+	shards := mgr.shardPorts
+	for _, shard := range getShardIndexes(shards) {
+		ports := shards[shard]
+		if shard == 0 || len(ports) == 0 {
+			continue
+		}
+		svc, err := mgr.getService(mgr.getShardName(shard))
+		if err != nil {
+			return err
+		}
+		if svc == nil {
+			continue
+		}
+		addr, err := mgr.resolver.resolve(svc)
+		if err != nil {
+			return err
+		}
+		if addr == "" || mgr.shardAddresses[svc.Name] == addr {
+			continue
+		}
+		mgr.shardAddresses[svc.Name] = addr
+		if addr == registered {
+			continue
+		}
+		sorted := sortPorts(ports)
+		msg := fmt.Sprintf("Proxy Service %s serves public ports %d to %d at %s instead of the registered address %s", svc.Name, sorted[0].Port, sorted[len(sorted)-1].Port, addr, registered)
+		mgr.log.Info(msg)
+		mgr.recordEvent(corev1.EventTypeWarning, "ProxyShardAddress", msg)
+	}
+	return nil
+}
+
+func (mgr *Manager) getService(name string) (*corev1.Service, error) {
+	svc := &corev1.Service{}
+	key := k8sclient.ObjectKey{
+		Name:      name,
+		Namespace: mgr.opt.Namespace,
+	}
+	if err := mgr.k8sClient.Get(context.TODO(), key, svc); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return svc, nil
+}
@@ -0,0 +1,138 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReconcileServiceShards(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.MaxServicePorts = 2
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, source)
+
+	getShardPorts := func(name string) []int32 {
+		t.Helper()
+		svc, err := mgr.getService(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if svc == nil {
+			return nil
+		}
+		ports := make([]int32, 0)
+		for _, port := range svc.Spec.Ports {
+			ports = append(ports, port.Port)
+		}
+		return ports
+	}
+	expectShards := func(expected map[string]string) {
+		t.Helper()
+		for name, ports := range expected {
+			if actual := fmt.Sprint(getShardPorts(name)); actual != ports {
+				t.Errorf("Expected Service %s to serve %s, got %s", name, ports, actual)
+			}
+		}
+	}
+
+	source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001), newPublicPort("c", "tcp", 5002),
+		newPublicPort("d", "tcp", 5003), newPublicPort("e", "tcp", 5004))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	expectShards(map[string]string{
+		"pot-proxy":         "[5000 5001]",
+		"pot-proxy-shard-1": "[5002 5003]",
+		"pot-proxy-shard-2": "[5004]",
+	})
+
+	// New port takes the freed slot, other ports stay on their Service
+	source.set(newPublicPort("f", "tcp", 6000), newPublicPort("a", "tcp", 5000), newPublicPort("c", "tcp", 5002),
+		newPublicPort("d", "tcp", 5003), newPublicPort("e", "tcp", 5004))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	expectShards(map[string]string{
+		"pot-proxy":         "[5000 6000]",
+		"pot-proxy-shard-1": "[5002 5003]",
+		"pot-proxy-shard-2": "[5004]",
+	})
+
+	// Restarted manager keeps the assignment, empty shards are deleted
+	mgr = newTestManager(opt, k8sClient, source)
+	if err := mgr.generateCache(); err != nil {
+		t.Fatal(err)
+	}
+	source.set(newPublicPort("f", "tcp", 6000), newPublicPort("a", "tcp", 5000), newPublicPort("c", "tcp", 5002),
+		newPublicPort("d", "tcp", 5003), newPublicPort("g", "tcp", 4000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	expectShards(map[string]string{
+		"pot-proxy":         "[5000 6000]",
+		"pot-proxy-shard-1": "[5002 5003]",
+		"pot-proxy-shard-2": "[4000]",
+	})
+
+	// First Service keeps the registered address while other shards serve ports
+	source.set(newPublicPort("c", "tcp", 5002), newPublicPort("d", "tcp", 5003), newPublicPort("g", "tcp", 4000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	expectShards(map[string]string{
+		"pot-proxy":         "[4000]",
+		"pot-proxy-shard-1": "[5002 5003]",
+		"pot-proxy-shard-2": "[]",
+	})
+
+	source.set(newPublicPort("f", "tcp", 6000), newPublicPort("a", "tcp", 5000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if k8sClient.count("Service") != 1 {
+		t.Errorf("Expected empty shard Services to be deleted")
+	}
+}
+
+func TestServiceShardsDryRun(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.MaxServicePorts = 2
+	opt.DryRun = true
+	mgr := newTestManager(opt, newFakeClient(), source)
+	mgr.cache = portMap{
+		5000: newPublicPort("a", "tcp", 5000).PublicPort,
+		5001: newPublicPort("b", "tcp", 5001).PublicPort,
+		5002: newPublicPort("c", "tcp", 5002).PublicPort,
+	}
+	mgr.shards = map[int]int{5000: 0, 5001: 0, 5002: 1}
+	mgr.assignShards()
+	live := fmt.Sprint(mgr.shardPorts)
+
+	// Planned removal of the first shard's ports does not move the live assignment
+	source.set(newPublicPort("c", "tcp", 5002))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(mgr.shards) != "map[5000:0 5001:0 5002:1]" || fmt.Sprint(mgr.shardPorts) != live {
+		t.Errorf("Expected dry run to keep the shard assignment, got %v", mgr.shards)
+	}
+	names := fmt.Sprint(mgr.getProxyServiceNames())
+	if again := fmt.Sprint(mgr.getProxyServiceNames()); again != names || names != "[pot-proxy pot-proxy-shard-1]" {
+		t.Errorf("Expected getters to read the stored assignment, got %s and %s", names, again)
+	}
+}
@@ -0,0 +1,208 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+// PublicPortSource provides the public ports which the manager exposes through the proxy
+type PublicPortSource interface {
+	GetPublicPorts() ([]ioclient.MicroservicePublicPort, error)
+}
+
+const (
+	PortSourceController = "controller"
+	PortSourceFile       = "file"
+	PortSourceCRD        = "crd"
+)
+
+// Implemented by sources which describe microservices themselves, the Controller is asked otherwise
+type microserviceDescriber interface {
+	describeMicroservice(uuid string) (name, application string, found bool)
+}
+
+// Names and applications of the microservices listed by a file or CRD source in its last read
+type describedMicroservices struct {
+	names        map[string]string
+	applications map[string]string
+}
+
+// Microservices without a name are named by their UUID
+func (described *describedMicroservices) record(ports []sourcePublicPort) []ioclient.MicroservicePublicPort {
+	described.names = make(map[string]string)
+	described.applications = make(map[string]string)
+	result := make([]ioclient.MicroservicePublicPort, 0, len(ports))
+	for _, port := range ports {
+		name := port.MicroserviceName
+		if name == "" {
+			name = port.MicroserviceUUID
+		}
+		described.names[port.MicroserviceUUID] = name
+		described.applications[port.MicroserviceUUID] = port.Application
+		result = append(result, port.MicroservicePublicPort)
+	}
+	return result
+}
+
+func (described *describedMicroservices) describeMicroservice(uuid string) (name, application string, found bool) {
+	name, found = described.names[uuid]
+	return name, described.applications[uuid], found
+}
+
+// Public port read from a file or CRD, the optional name and application stand in for the Controller's details
+type sourcePublicPort struct {
+	ioclient.MicroservicePublicPort
+	MicroserviceName string `json:"microserviceName,omitempty"`
+	Application      string `json:"application,omitempty"`
+}
+
+func checkPublicPort(port int) error {
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("publicPort %d out of range", port)
+	}
+	return nil
+}
+
+// Custom resource read by the CRD port source
+var publicPortGVK = schema.GroupVersionKind{
+	Group:   "datasance.com",
+	Version: "v3",
+	Kind:    "PublicPortList",
+}
+
+func newPortSource(mgr *Manager) (PublicPortSource, error) {
+	switch mgr.opt.PortSource {
+	case "", PortSourceController:
+		return &controllerPortSource{mgr: mgr}, nil
+	case PortSourceFile:
+		if mgr.opt.PortSourceFile == "" {
+			return nil, errors.New("file port source requires a file path")
+		}
+		return &filePortSource{path: mgr.opt.PortSourceFile}, nil
+	case PortSourceCRD:
+		return &crdPortSource{k8sClient: mgr.k8sClient, namespace: mgr.opt.Namespace}, nil
+	}
+	return nil, fmt.Errorf("unsupported port source %s", mgr.opt.PortSource)
+}
+
+// Queries the Controller REST API
+// Holds the manager rather than the client because the client is replaced on every login
+type controllerPortSource struct {
+	mgr *Manager
+}
+
+func (src *controllerPortSource) GetPublicPorts() ([]ioclient.MicroservicePublicPort, error) {
+	var ports []ioclient.MicroservicePublicPort
+	err := src.mgr.callController(func(client *ioclient.Client) (err error) {
+		ports, err = client.GetAllMicroservicePublicPorts()
+		return
+	})
+	return ports, err
+}
+
+// Reads a YAML or JSON list of public ports, re-read on every call so edits are picked up
+type filePortSource struct {
+	describedMicroservices
+	path string
+}
+
+func (src *filePortSource) GetPublicPorts() ([]ioclient.MicroservicePublicPort, error) {
+	content, err := os.ReadFile(src.path)
+	if err != nil {
+		return nil, err
+	}
+	ports := make([]sourcePublicPort, 0)
+	if err := yaml.Unmarshal(content, &ports); err != nil {
+		return nil, fmt.Errorf("could not parse port source file %s: %s", src.path, err.Error())
+	}
+	for _, port := range ports {
+		if err := checkPublicPort(port.PublicPort.Port); err != nil {
+			return nil, fmt.Errorf("invalid port of microservice %s in port source file %s: %s", port.MicroserviceUUID, src.path, err.Error())
+		}
+	}
+	return src.record(ports), nil
+}
+
+// Lists PublicPort custom resources in the namespace of the manager
+type crdPortSource struct {
+	describedMicroservices
+	k8sClient k8sclient.Client
+	namespace string
+}
+
+func (src *crdPortSource) GetPublicPorts() ([]ioclient.MicroservicePublicPort, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(publicPortGVK)
+	if err := src.k8sClient.List(context.TODO(), list, k8sclient.InNamespace(src.namespace)); err != nil {
+		return nil, err
+	}
+	ports := make([]sourcePublicPort, 0, len(list.Items))
+	for idx := range list.Items {
+		item := &list.Items[idx]
+		port, err := decodePublicPortResource(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PublicPort %s: %s", item.GetName(), err.Error())
+		}
+		ports = append(ports, port)
+	}
+	return src.record(ports), nil
+}
+
+func decodePublicPortResource(item *unstructured.Unstructured) (port sourcePublicPort, err error) {
+	spec, found, err := unstructured.NestedMap(item.Object, "spec")
+	if err != nil {
+		return
+	}
+	if !found {
+		err = errors.New("spec not found")
+		return
+	}
+	if port.MicroserviceUUID, _, err = unstructured.NestedString(spec, "microserviceUuid"); err != nil {
+		return
+	}
+	if port.PublicPort.Protocol, _, err = unstructured.NestedString(spec, "protocol"); err != nil {
+		return
+	}
+	if port.PublicPort.Queue, _, err = unstructured.NestedString(spec, "queueName"); err != nil {
+		return
+	}
+	if port.MicroserviceName, _, err = unstructured.NestedString(spec, "microserviceName"); err != nil {
+		return
+	}
+	if port.Application, _, err = unstructured.NestedString(spec, "application"); err != nil {
+		return
+	}
+	number, found, err := unstructured.NestedInt64(spec, "publicPort")
+	if err != nil {
+		return
+	}
+	if !found {
+		err = errors.New("publicPort not set")
+		return
+	}
+	port.PublicPort.Port = int(number)
+	err = checkPublicPort(port.PublicPort.Port)
+	return
+}
@@ -0,0 +1,207 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestFilePortSource(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		content     string
+		valid       bool
+		description string // Name and application of microservice abc
+	}{
+		{
+			name: "unnamed",
+			content: `
+- microserviceUuid: abc
+  publicPort:
+    protocol: tcp
+    queueName: abc
+    publicPort: 5000
+`,
+			valid:       true,
+			description: "abc/",
+		},
+		{
+			name: "named",
+			content: `
+- microserviceUuid: abc
+  microserviceName: frontend
+  application: shop
+  publicPort:
+    protocol: tcp
+    queueName: abc
+    publicPort: 5000
+`,
+			valid:       true,
+			description: "frontend/shop",
+		},
+		{
+			name: "port missing",
+			content: `
+- microserviceUuid: abc
+  publicPort:
+    protocol: tcp
+    queueName: abc
+`,
+		},
+		{
+			name: "port out of range",
+			content: `
+- microserviceUuid: abc
+  publicPort:
+    protocol: tcp
+    queueName: abc
+    publicPort: 70000
+`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ports.yaml")
+			if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			source := &filePortSource{path: path}
+			ports, err := source.GetPublicPorts()
+			if !test.valid {
+				if err == nil {
+					t.Errorf("Expected invalid ports to be refused, got %v", ports)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ports) != 1 || ports[0].PublicPort.Port != 5000 || ports[0].MicroserviceUUID != "abc" {
+				t.Errorf("Unexpected ports %v", ports)
+			}
+			name, application, found := source.describeMicroservice("abc")
+			if !found || name+"/"+application != test.description {
+				t.Errorf("Expected microservice %s, got %s/%s", test.description, name, application)
+			}
+		})
+	}
+}
+
+func TestDecodePublicPortResource(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		spec  map[string]interface{}
+		valid bool
+	}{
+		{
+			name: "valid",
+			spec: map[string]interface{}{
+				"microserviceUuid": "abc",
+				"microserviceName": "frontend",
+				"application":      "shop",
+				"protocol":         "http",
+				"queueName":        "abc",
+				"publicPort":       int64(5000),
+			},
+			valid: true,
+		},
+		{
+			name:  "spec missing",
+			valid: false,
+		},
+		{
+			name: "port missing",
+			spec: map[string]interface{}{
+				"microserviceUuid": "abc",
+				"protocol":         "tcp",
+				"queueName":        "abc",
+			},
+		},
+		{
+			name: "port zero",
+			spec: map[string]interface{}{
+				"microserviceUuid": "abc",
+				"protocol":         "tcp",
+				"queueName":        "abc",
+				"publicPort":       int64(0),
+			},
+		},
+		{
+			name: "port not a number",
+			spec: map[string]interface{}{
+				"microserviceUuid": "abc",
+				"protocol":         "tcp",
+				"queueName":        "abc",
+				"publicPort":       "5000",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			item := &unstructured.Unstructured{Object: map[string]interface{}{}}
+			if test.spec != nil {
+				item.Object["spec"] = test.spec
+			}
+			port, err := decodePublicPortResource(item)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("Expected resource to be refused, got %v", port)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if port.MicroserviceUUID != "abc" || port.PublicPort.Protocol != "http" || port.PublicPort.Queue != "abc" || port.PublicPort.Port != 5000 {
+				t.Errorf("Unexpected port %v", port)
+			}
+			if port.MicroserviceName != "frontend" || port.Application != "shop" {
+				t.Errorf("Unexpected microservice %s/%s", port.MicroserviceName, port.Application)
+			}
+		})
+	}
+}
+
+// File source names microservices for virtual hosts without a Controller
+func TestFilePortSourceWithoutController(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ports.yaml")
+	content := `
+- microserviceUuid: abc
+  microserviceName: frontend
+  application: shop
+  publicPort:
+    protocol: http
+    queueName: abc
+    publicPort: 5000
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	opt := newTestOptions()
+	opt.AuthURL = ""
+	opt.HTTPBaseDomain = "apps.example.com"
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, &filePortSource{path: path})
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.hostnames[5000] != "frontend.shop.apps.example.com" {
+		t.Errorf("Expected hostname from the port source, got %q", mgr.hostnames[5000])
+	}
+	if mgr.queue.Len() != 0 {
+		t.Errorf("Expected no address registration without a Controller")
+	}
+}
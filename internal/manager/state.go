@@ -0,0 +1,292 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+// Keys of the state ConfigMap, the response, firstSeen and goodRevision are gzip compressed binary data
+const (
+	stateAddressKey  = "address"
+	stateResponseKey = "response"
+	stateLastSyncKey = "lastSync"
+	stateStaleKey    = "stale"
+	// First observation of each microservice in Unix seconds, kept for the oldest conflict policy
+	stateFirstSeenKey = "firstSeen"
+	// Template of the last completed rollout, restored so that a restarted manager can roll back
+	stateGoodRevisionKey = "goodRevision"
+)
+
+type persistedRevision struct {
+	Revision string                  `json:"revision"`
+	Template *corev1.PodTemplateSpec `json:"template"`
+}
+
+// Last known state of a manager, persisted so that it survives restarts and source outages
+// Only accessed from the work queue, so it needs no locking
+type managerState struct {
+	address  string // Proxy address registered with the Controller
+	response []ioclient.MicroservicePublicPort
+	started  time.Time
+	lastSync time.Time // Last successful read of the port source
+	stale    bool
+	written  map[string]string // Data of the ConfigMap as last written
+
+	// Whether response was read or restored, a response too large for the ConfigMap is not persisted
+	hasResponse bool
+	oversize    bool
+}
+
+func (mgr *Manager) getStateName() string {
+	return mgr.opt.ProxyName + "-state"
+}
+
+// Record a successful read of the port source
+func (mgr *Manager) recordSync(response []ioclient.MicroservicePublicPort) {
+	mgr.state.response = response
+	mgr.state.hasResponse = true
+	mgr.state.lastSync = time.Now()
+}
+
+// Write the registered address and last source response to a ConfigMap if anything changed
+func (mgr *Manager) persistState() {
+	if !mgr.opt.PersistState {
+		return
+	}
+
+	// Report staleness, a manager which never synced is measured from its start
+	lastSync := mgr.state.lastSync
+	if lastSync.IsZero() {
+		lastSync = mgr.state.started
+	}
+	sinceSync := time.Since(lastSync)
+	stale := sinceSync > mgr.opt.StateStaleAfter
+	setGauge(metrics.secondsSinceSync, mgr.opt.ProxyName, int64(sinceSync.Seconds()))
+	if stale && !mgr.state.stale {
+		message := fmt.Sprintf("Public ports have not been read from the %s port source since %s, serving last known state",
+			mgr.opt.PortSource, lastSync.Format(time.RFC3339))
+		mgr.log.Info(message)
+		mgr.recordEvent(corev1.EventTypeWarning, "PublicPortsStale", message)
+	}
+	mgr.state.stale = stale
+
+	data := map[string]string{
+		stateAddressKey:  mgr.state.address,
+		stateLastSyncKey: mgr.state.lastSync.Format(time.RFC3339),
+		stateStaleKey:    strconv.FormatBool(stale),
+	}
+	binaryData := make(map[string][]byte)
+	if mgr.opt.ConflictPolicy == ConflictPolicyOldest {
+		firstSeen := make(map[string]int64, len(mgr.firstSeen))
+		for uuid, seen := range mgr.firstSeen {
+			firstSeen[uuid] = seen.Unix()
+		}
+		encoded, err := encodeStateValue(firstSeen)
+		if err != nil {
+			mgr.log.Error(err, "Failed to encode state")
+			return
+		}
+		binaryData[stateFirstSeenKey] = encoded
+	}
+	if mgr.rollout.goodTemplate != nil {
+		encoded, err := encodeStateValue(persistedRevision{Revision: mgr.rollout.goodRevision, Template: mgr.rollout.goodTemplate})
+		if err != nil {
+			mgr.log.Error(err, "Failed to encode state")
+			return
+		}
+		binaryData[stateGoodRevisionKey] = encoded
+	}
+	response, err := encodeStateValue(mgr.state.response)
+	if err != nil {
+		mgr.log.Error(err, "Failed to encode state")
+		return
+	}
+	// The response is only a fallback for source outages, it is dropped rather than failing the whole state
+	oversize := getStateSize(data, binaryData)+len(stateResponseKey)+len(response) > maxConfigMapSize
+	if !oversize {
+		binaryData[stateResponseKey] = response
+	} else if !mgr.state.oversize {
+		message := fmt.Sprintf("Source response of %d public ports does not fit into ConfigMap %s, it will not be served during source outages after a restart",
+			len(mgr.state.response), mgr.getStateName())
+		mgr.log.Info(message)
+		mgr.recordEvent(corev1.EventTypeWarning, "StateTooLarge", message)
+	}
+	mgr.state.oversize = oversize
+	written := make(map[string]string, len(data)+len(binaryData))
+	for key, value := range data {
+		written[key] = value
+	}
+	for key, value := range binaryData {
+		written[key] = string(value)
+	}
+	if !mgr.stateChanged(written) {
+		return
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mgr.getStateName(),
+			Namespace: mgr.opt.Namespace,
+			Labels: map[string]string{
+				"name": mgr.opt.ProxyName,
+			},
+		},
+		Data:       data,
+		BinaryData: binaryData,
+	}
+	found := corev1.ConfigMap{}
+	err = mgr.k8sClient.Get(context.TODO(), k8sclient.ObjectKeyFromObject(configMap), &found)
+	switch {
+	case k8serrors.IsNotFound(err):
+		mgr.setOwnerReference(configMap)
+		err = mgr.create(configMap)
+	case err == nil:
+		found.Data = data
+		found.BinaryData = binaryData
+		err = mgr.update(&found)
+	}
+	if err != nil {
+		mgr.log.Error(err, "Failed to persist state")
+		return
+	}
+	mgr.state.written = written
+}
+
+// Size of the ConfigMap data as counted against its limit
+func getStateSize(data map[string]string, binaryData map[string][]byte) (size int) {
+	for key, value := range data {
+		size += len(key) + len(value)
+	}
+	for key, value := range binaryData {
+		size += len(key) + len(value)
+	}
+	return
+}
+
+// JSON encoded and gzip compressed, the response of ten thousand ports compresses to a few hundred KiB
+func encodeStateValue(value interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(encoded); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeStateValue(compressed []byte, value interface{}) error {
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	encoded, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, value)
+}
+
+// Only the sync time changes every cycle, it is written at most once per stateSyncInterval
+func (mgr *Manager) stateChanged(data map[string]string) bool {
+	written := mgr.state.written
+	if written == nil {
+		return true
+	}
+	for key, value := range data {
+		if key != stateLastSyncKey && written[key] != value {
+			return true
+		}
+	}
+	lastWritten, err := time.Parse(time.RFC3339, written[stateLastSyncKey])
+	if err != nil {
+		return true
+	}
+	return mgr.state.lastSync.Sub(lastWritten) >= pkg.stateSyncInterval
+}
+
+// Read the persisted state, the cache is only ever generated from the proxy so that a deleted proxy is not recreated from it
+func (mgr *Manager) restoreState() error {
+	if !mgr.opt.PersistState {
+		return nil
+	}
+	stateKey := k8sclient.ObjectKey{
+		Name:      mgr.getStateName(),
+		Namespace: mgr.opt.Namespace,
+	}
+	configMap := corev1.ConfigMap{}
+	if err := mgr.k8sClient.Get(context.TODO(), stateKey, &configMap); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	mgr.state.address = configMap.Data[stateAddressKey]
+	if lastSync, err := time.Parse(time.RFC3339, configMap.Data[stateLastSyncKey]); err == nil {
+		mgr.state.lastSync = lastSync
+	}
+	if response, exists := configMap.BinaryData[stateResponseKey]; exists {
+		if err := decodeStateValue(response, &mgr.state.response); err != nil {
+			return fmt.Errorf("could not decode persisted source response: %s", err.Error())
+		}
+		mgr.state.hasResponse = true
+	}
+	if encoded, exists := configMap.BinaryData[stateFirstSeenKey]; exists {
+		firstSeen := make(map[string]int64)
+		if err := decodeStateValue(encoded, &firstSeen); err != nil {
+			return fmt.Errorf("could not decode persisted microservice observations: %s", err.Error())
+		}
+		for uuid, seen := range firstSeen {
+			mgr.firstSeen[uuid] = time.Unix(seen, 0)
+		}
+	}
+	if encoded, exists := configMap.BinaryData[stateGoodRevisionKey]; exists {
+		good := persistedRevision{}
+		if err := decodeStateValue(encoded, &good); err != nil {
+			return fmt.Errorf("could not decode persisted Proxy revision: %s", err.Error())
+		}
+		mgr.rollout.goodTemplate = good.Template
+		mgr.rollout.goodRevision = good.Revision
+	}
+	mgr.state.written = make(map[string]string, len(configMap.Data)+len(configMap.BinaryData))
+	for key, value := range configMap.Data {
+		mgr.state.written[key] = value
+	}
+	for key, value := range configMap.BinaryData {
+		mgr.state.written[key] = string(value)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+)
+
+func TestReconcilePersistState(t *testing.T) {
+	testCases := []struct {
+		name      string
+		sourceErr error
+		ports     []ioclient.MicroservicePublicPort
+		expected  int
+	}{
+		{
+			name:     "source available",
+			ports:    []ioclient.MicroservicePublicPort{newPublicPort("a", "tcp", 5000)},
+			expected: 1,
+		},
+		{
+			name:      "source unavailable",
+			sourceErr: errors.New("controller unavailable"),
+			expected:  2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := &fakePortSource{}
+			opt := newTestOptions()
+			opt.PersistState = true
+			k8sClient := newFakeClient()
+			mgr := newTestManager(opt, k8sClient, source)
+
+			source.set(newPublicPort("a", "tcp", 5000), newPublicPort("b", "tcp", 5001))
+			if err := mgr.run(); err != nil {
+				t.Fatal(err)
+			}
+			mgr.state.address = "1.2.3.4"
+			mgr.persistState()
+
+			// Proxy Deployment is deleted while the manager is down
+			dep, _ := getProxyObjects(t, mgr)
+			if err := k8sClient.Delete(context.TODO(), dep); err != nil {
+				t.Fatal(err)
+			}
+			source.set(tc.ports...)
+			source.err = tc.sourceErr
+
+			restarted := newTestManager(opt, k8sClient, source)
+			if err := restarted.generateCache(); err != nil {
+				t.Fatal(err)
+			}
+			if len(restarted.cache) != 0 || restarted.state.address != "1.2.3.4" {
+				t.Errorf("Expected address without cache to be restored, got cache %v and address %s", restarted.cache, restarted.state.address)
+			}
+			if err := restarted.run(); err != tc.sourceErr {
+				t.Fatalf("Expected error %v, got %v", tc.sourceErr, err)
+			}
+			if len(restarted.cache) != tc.expected {
+				t.Errorf("Expected %d ports, got %v", tc.expected, restarted.cache)
+			}
+			if dep, _ = getProxyObjects(t, restarted); dep == nil {
+				t.Errorf("Expected Proxy Deployment to be created")
+			}
+		})
+	}
+}
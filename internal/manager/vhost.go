@@ -0,0 +1,365 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// HTTP public ports are virtually hosted when a base domain is configured
+// The proxy cannot route by Host header, so the Ingress controller provides the shared listener and routes each hostname to the proxy port
+func (mgr *Manager) isVirtualHosting() bool {
+	return mgr.opt.HTTPBaseDomain != ""
+}
+
+func (mgr *Manager) isVirtualHosted(port ioclient.PublicPort) bool {
+	return mgr.isVirtualHosting() && strings.EqualFold(port.Protocol, "http")
+}
+
+// Virtually hosted ports are reached from the Ingress controller rather than the clients allowed by the NetworkPolicy
+func (mgr *Manager) allowsIngressController() bool {
+	return mgr.isVirtualHosting() && len(mgr.opt.HTTPIngressNamespaceSelector) != 0
+}
+
+// A NetworkPolicy restricted to CIDRs or namespaces would block the Ingress controller unless its namespaces are known
+func (mgr *Manager) checkIngressController() error {
+	restricted := len(mgr.opt.NetworkPolicyCIDRs) != 0 || len(mgr.opt.NetworkPolicyNamespaceSelector) != 0
+	if mgr.isVirtualHosting() && mgr.opt.ProxyNetworkPolicy && restricted && !mgr.allowsIngressController() {
+		return errors.New("virtual hosting behind a restricted NetworkPolicy requires the namespace selector of the Ingress controller")
+	}
+	return nil
+}
+
+// Allow the Ingress controller to virtually hosted ports, replacing the rule of the other ports when there are none
+func (mgr *Manager) setIngressControllerRule(policy *networkingv1.NetworkPolicy) {
+	if !mgr.allowsIngressController() {
+		return
+	}
+	ports := mgr.getVirtualHostPorts()
+	if len(ports) == 0 {
+		return
+	}
+	rule := networkingv1.NetworkPolicyIngressRule{
+		Ports: getNetworkPolicyPorts(ports),
+		From: []networkingv1.NetworkPolicyPeer{
+			{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: mgr.opt.HTTPIngressNamespaceSelector},
+			},
+		},
+	}
+	// A rule without ports would allow every port
+	if len(policy.Spec.Ingress[0].Ports) == 0 {
+		policy.Spec.Ingress = nil
+	}
+	policy.Spec.Ingress = append(policy.Spec.Ingress, rule)
+}
+
+// Name of the Service and Ingress of virtually hosted ports
+func (mgr *Manager) getVirtualHostName() string {
+	return mgr.opt.ProxyName + "-vhost"
+}
+
+// Cached ports exposed on the proxy Services
+func (mgr *Manager) getServicePorts() portMap {
+	if !mgr.isVirtualHosting() {
+		return mgr.cache
+	}
+	ports := make(portMap)
+	for number, port := range mgr.cache {
+		if !mgr.isVirtualHosted(port) {
+			ports[number] = port
+		}
+	}
+	return ports
+}
+
+// Cached ports reached through the Ingress
+func (mgr *Manager) getVirtualHostPorts() portMap {
+	ports := make(portMap)
+	for number, port := range mgr.cache {
+		if mgr.isVirtualHosted(port) {
+			ports[number] = port
+		}
+	}
+	return ports
+}
+
+// Hostname of each HTTP port, <microservice>.<application>.<base domain>
+// Ports keep their assigned hostname, further ports of a microservice get their port appended to the first label
+func (mgr *Manager) assignHostnames(ports []ioclient.MicroservicePublicPort) (changed bool, err error) {
+	sorted := make([]ioclient.MicroservicePublicPort, 0, len(ports))
+	for _, port := range ports {
+		if mgr.isVirtualHosted(port.PublicPort) {
+			sorted = append(sorted, port)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].PublicPort.Port < sorted[j].PublicPort.Port
+	})
+
+	// Plain and suffixed hostname of each port
+	candidates := make(map[int][2]string)
+	for idx := range sorted {
+		port := &sorted[idx]
+		name, err := mgr.getMicroserviceName(port.MicroserviceUUID)
+		if err != nil {
+			return false, err
+		}
+		application, err := mgr.getApplication(port.MicroserviceUUID)
+		if err != nil {
+			return false, err
+		}
+		label := sanitizeName(name)
+		if label == "" {
+			label = sanitizeName(port.MicroserviceUUID)
+		}
+		suffix := mgr.opt.HTTPBaseDomain
+		if application = sanitizeName(application); application != "" {
+			suffix = application + "." + suffix
+		}
+		numbered := label + "-" + strconv.Itoa(port.PublicPort.Port)
+		candidates[port.PublicPort.Port] = [2]string{
+			label + "." + suffix,
+			shortenName(numbered, numbered, maxResourceNameSize) + "." + suffix,
+		}
+	}
+
+	// Assigned ports keep their hostname while it still matches their microservice
+	hostnames := make(map[int]string)
+	used := make(map[string]bool)
+	for idx := range sorted {
+		port := sorted[idx].PublicPort.Port
+		hostname, assigned := mgr.hostnames[port]
+		if candidate := candidates[port]; assigned && !used[hostname] && (hostname == candidate[0] || hostname == candidate[1]) {
+			used[hostname] = true
+			hostnames[port] = hostname
+		}
+	}
+	for idx := range sorted {
+		port := sorted[idx].PublicPort.Port
+		if _, exists := hostnames[port]; exists {
+			continue
+		}
+		hostname := candidates[port][0]
+		if used[hostname] {
+			hostname = candidates[port][1]
+		}
+		used[hostname] = true
+		hostnames[port] = hostname
+	}
+
+	// Ports kept in the cache, e.g. by the deletion guard, keep their hostname
+	for port, hostname := range mgr.hostnames {
+		if _, exists := hostnames[port]; !exists && !used[hostname] {
+			if _, cached := mgr.cache[port]; cached {
+				hostnames[port] = hostname
+			}
+		}
+	}
+
+	numbers := make([]int, 0, len(hostnames))
+	for port := range hostnames {
+		numbers = append(numbers, port)
+	}
+	sort.Ints(numbers)
+	for _, port := range numbers {
+		hostname := hostnames[port]
+		if mgr.hostnames[port] == hostname {
+			continue
+		}
+		changed = true
+		msg := fmt.Sprintf("Public port %d is served at http://%s", port, hostname)
+		mgr.log.Info(msg)
+		mgr.recordEvent(corev1.EventTypeNormal, "PublicPortHostname", msg)
+	}
+	if len(hostnames) != len(mgr.hostnames) {
+		changed = true
+	}
+	mgr.hostnames = hostnames
+	return changed, nil
+}
+
+// Read the hostname of each port from the existing Ingress
+func (mgr *Manager) restoreHostnames() error {
+	if !mgr.isVirtualHosting() {
+		return nil
+	}
+	ingress := networkingv1.Ingress{}
+	key := k8sclient.ObjectKey{
+		Name:      mgr.getVirtualHostName(),
+		Namespace: mgr.opt.Namespace,
+	}
+	if err := mgr.k8sClient.Get(context.TODO(), key, &ingress); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service != nil {
+				mgr.hostnames[int(path.Backend.Service.Port.Number)] = rule.Host
+			}
+		}
+	}
+	return nil
+}
+
+// ClusterIP Service backing the Ingress, selecting the same proxy pods as the proxy Service
+func (mgr *Manager) newVirtualHostService(ports portMap) *corev1.Service {
+	svc := mgr.newProxyService()
+	svc.Name = mgr.getVirtualHostName()
+	svc.Labels = map[string]string{
+		"name": mgr.opt.ProxyName,
+	}
+	svc.Annotations = nil
+	svc.Spec.Type = corev1.ServiceTypeClusterIP
+	svc.Spec.ExternalTrafficPolicy = ""
+	svc.Spec.ExternalIPs = nil
+	modifyServiceSpec(svc, ports, nil)
+	return svc
+}
+
+func (mgr *Manager) newVirtualHostIngress(ports portMap) *networkingv1.Ingress {
+	pathType := networkingv1.PathTypePrefix
+	rules := make([]networkingv1.IngressRule, 0, len(ports))
+	hosts := make([]string, 0, len(ports))
+	for _, port := range sortPorts(ports) {
+		hostname, exists := mgr.hostnames[port.Port]
+		if !exists {
+			continue
+		}
+		hosts = append(hosts, hostname)
+		rules = append(rules, networkingv1.IngressRule{
+			Host: hostname,
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{
+						{
+							Path:     "/",
+							PathType: &pathType,
+							Backend: networkingv1.IngressBackend{
+								Service: &networkingv1.IngressServiceBackend{
+									Name: mgr.getVirtualHostName(),
+									Port: networkingv1.ServiceBackendPort{Number: int32(port.Port)},
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Host < rules[j].Host
+	})
+	sort.Strings(hosts)
+
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      mgr.getVirtualHostName(),
+			Namespace: mgr.opt.Namespace,
+			Labels: map[string]string{
+				"name": mgr.opt.ProxyName,
+			},
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: rules,
+		},
+	}
+	if mgr.opt.HTTPIngressClass != "" {
+		ingressClass := mgr.opt.HTTPIngressClass
+		ingress.Spec.IngressClassName = &ingressClass
+	}
+	if mgr.opt.HTTPTLSSecret != "" {
+		ingress.Spec.TLS = []networkingv1.IngressTLS{
+			{
+				Hosts:      hosts,
+				SecretName: mgr.opt.HTTPTLSSecret,
+			},
+		}
+	}
+	return ingress
+}
+
+// Create, update or delete the Service and Ingress of virtually hosted ports
+func (mgr *Manager) updateVirtualHosts() error {
+	if !mgr.isVirtualHosting() {
+		return nil
+	}
+	ports := mgr.getVirtualHostPorts()
+
+	// Service
+	desiredSvc := mgr.newVirtualHostService(ports)
+	foundSvc := corev1.Service{}
+	err := mgr.k8sClient.Get(context.TODO(), k8sclient.ObjectKeyFromObject(desiredSvc), &foundSvc)
+	switch {
+	case err != nil && !k8serrors.IsNotFound(err):
+		return err
+	case len(ports) == 0:
+		if err == nil {
+			if err := mgr.delete(&foundSvc); err != nil && !k8serrors.IsNotFound(err) {
+				return err
+			}
+		}
+	case err != nil:
+		mgr.setOwnerReference(desiredSvc)
+		if err := mgr.create(desiredSvc); err != nil {
+			return err
+		}
+	case reconcileProxyService(&foundSvc, desiredSvc):
+		if err := mgr.update(&foundSvc); err != nil {
+			return err
+		}
+	}
+
+	// Ingress
+	desired := mgr.newVirtualHostIngress(ports)
+	found := networkingv1.Ingress{}
+	err = mgr.k8sClient.Get(context.TODO(), k8sclient.ObjectKeyFromObject(desired), &found)
+	switch {
+	case err != nil && !k8serrors.IsNotFound(err):
+		return err
+	case len(desired.Spec.Rules) == 0:
+		if err == nil {
+			if err := mgr.delete(&found); err != nil && !k8serrors.IsNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	case err != nil:
+		mgr.setOwnerReference(desired)
+		return mgr.create(desired)
+	case equality.Semantic.DeepEqual(found.Spec, desired.Spec):
+		return nil
+	}
+	found.Spec = desired.Spec
+	return mgr.update(&found)
+}
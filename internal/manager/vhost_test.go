@@ -0,0 +1,194 @@
+/*
+ *  *******************************************************************************
+ *  * Copyright (c) 2023 Datasance Teknoloji A.S.
+ *  *
+ *  * This program and the accompanying materials are made available under the
+ *  * terms of the Eclipse Public License v. 2.0 which is available at
+ *  * http://www.eclipse.org/legal/epl-2.0
+ *  *
+ *  * SPDX-License-Identifier: EPL-2.0
+ *  *******************************************************************************
+ *
+ */
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	ioclient "github.com/datasance/iofog-go-sdk/v3/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8sclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestReconcileVirtualHosts(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.HTTPBaseDomain = "apps.example.com"
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, source)
+	mgr.applications = map[string]string{"a": "Web Shop", "b": "Web Shop"}
+	mgr.microserviceNames = map[string]string{"a": "frontend", "b": "db"}
+
+	source.set(newPublicPort("a", "http", 5000), newPublicPort("a", "http", 5001), newPublicPort("b", "tcp", 6000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	dep, svc := getProxyObjects(t, mgr)
+	if config, _ := getProxyConfig(dep); strings.Count(config, "=>") != 3 {
+		t.Errorf("Expected proxy to serve all ports, got %s", config)
+	}
+	if len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Port != 6000 {
+		t.Errorf("Expected only TCP port on proxy Service, got %v", svc.Spec.Ports)
+	}
+	vhostSvc, err := mgr.getService(mgr.getVirtualHostName())
+	if err != nil || vhostSvc == nil || vhostSvc.Spec.Type != corev1.ServiceTypeClusterIP || len(vhostSvc.Spec.Ports) != 2 {
+		t.Fatalf("Expected ClusterIP Service with HTTP ports, got %v", vhostSvc)
+	}
+	ingress := networkingv1.Ingress{}
+	if err := k8sClient.Get(context.TODO(), k8sclient.ObjectKey{Name: mgr.getVirtualHostName(), Namespace: opt.Namespace}, &ingress); err != nil {
+		t.Fatal(err)
+	}
+	routes := make(map[string]int32)
+	for _, rule := range ingress.Spec.Rules {
+		routes[rule.Host] = rule.HTTP.Paths[0].Backend.Service.Port.Number
+	}
+	expected := map[string]int32{"frontend.web-shop.apps.example.com": 5000, "frontend-5001.web-shop.apps.example.com": 5001}
+	if fmt.Sprint(routes) != fmt.Sprint(expected) {
+		t.Errorf("Expected routes %v, got %v", expected, routes)
+	}
+
+	// Restarted manager keeps the hostnames, removed ports lose their route
+	mgr = newTestManager(opt, k8sClient, source)
+	if err := mgr.generateCache(); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.hostnames[5001] != "frontend-5001.web-shop.apps.example.com" {
+		t.Errorf("Expected hostnames from Ingress, got %v", mgr.hostnames)
+	}
+	mgr.applications = map[string]string{"a": "Web Shop", "b": "Web Shop"}
+	mgr.microserviceNames = map[string]string{"a": "frontend", "b": "db"}
+	source.set(newPublicPort("b", "tcp", 6000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	if k8sClient.count("Ingress") != 0 || k8sClient.count("Service") != 1 {
+		t.Errorf("Expected Ingress and its Service to be deleted without HTTP ports")
+	}
+}
+
+func TestAssignHostnames(t *testing.T) {
+	long := strings.Repeat("frontend", 10)
+	label := shortenName(long, long, maxResourceNameSize)
+	testCases := []struct {
+		name     string
+		assigned map[int]string
+		ports    []ioclient.MicroservicePublicPort
+		expected map[int]string
+	}{
+		{
+			name:     "further ports are numbered",
+			ports:    []ioclient.MicroservicePublicPort{newPublicPort("a", "http", 5001), newPublicPort("a", "http", 5000)},
+			expected: map[int]string{5000: "frontend.web-shop.apps.example.com", 5001: "frontend-5001.web-shop.apps.example.com"},
+		},
+		{
+			name:     "assigned ports keep their hostname",
+			assigned: map[int]string{5001: "frontend.web-shop.apps.example.com"},
+			ports:    []ioclient.MicroservicePublicPort{newPublicPort("a", "http", 5001), newPublicPort("a", "http", 5000)},
+			expected: map[int]string{5000: "frontend-5000.web-shop.apps.example.com", 5001: "frontend.web-shop.apps.example.com"},
+		},
+		{
+			name:     "hostnames of other microservices are reassigned",
+			assigned: map[int]string{5000: "db.web-shop.apps.example.com"},
+			ports:    []ioclient.MicroservicePublicPort{newPublicPort("a", "http", 5000)},
+			expected: map[int]string{5000: "frontend.web-shop.apps.example.com"},
+		},
+		{
+			name:  "long labels are shortened",
+			ports: []ioclient.MicroservicePublicPort{newPublicPort("b", "http", 5000), newPublicPort("b", "http", 5001)},
+			expected: map[int]string{
+				5000: label + ".web-shop.apps.example.com",
+				5001: shortenName(label+"-5001", label+"-5001", maxResourceNameSize) + ".web-shop.apps.example.com",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opt := newTestOptions()
+			opt.HTTPBaseDomain = "apps.example.com"
+			mgr := newTestManager(opt, newFakeClient(), &fakePortSource{})
+			mgr.applications = map[string]string{"a": "Web Shop", "b": "Web Shop"}
+			mgr.microserviceNames = map[string]string{"a": "frontend", "b": long}
+			if tc.assigned != nil {
+				mgr.hostnames = tc.assigned
+			}
+			if _, err := mgr.assignHostnames(tc.ports); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(mgr.hostnames) != fmt.Sprint(tc.expected) {
+				t.Errorf("Expected hostnames %v, got %v", tc.expected, mgr.hostnames)
+			}
+			for _, hostname := range mgr.hostnames {
+				if label := strings.Split(hostname, ".")[0]; len(label) > maxResourceNameSize {
+					t.Errorf("Expected labels of at most %d characters, got %s", maxResourceNameSize, label)
+				}
+			}
+		})
+	}
+}
+
+func TestVirtualHostNetworkPolicy(t *testing.T) {
+	source := &fakePortSource{}
+	opt := newTestOptions()
+	opt.HTTPBaseDomain = "apps.example.com"
+	opt.ProxyNetworkPolicy = true
+	opt.NetworkPolicyCIDRs = []string{"203.0.113.0/24"}
+	opt.HTTPIngressNamespaceSelector = map[string]string{corev1.LabelMetadataName: "ingress-nginx"}
+	k8sClient := newFakeClient()
+	mgr := newTestManager(opt, k8sClient, source)
+	mgr.applications = map[string]string{"a": "Web Shop", "b": "Web Shop"}
+	mgr.microserviceNames = map[string]string{"a": "frontend", "b": "db"}
+	getRules := func() map[string]string {
+		t.Helper()
+		policy := networkingv1.NetworkPolicy{}
+		if err := k8sClient.Get(context.TODO(), k8sclient.ObjectKey{Name: opt.ProxyName, Namespace: opt.Namespace}, &policy); err != nil {
+			t.Fatal(err)
+		}
+		rules := make(map[string]string)
+		for _, rule := range policy.Spec.Ingress {
+			ports := make([]int, 0, len(rule.Ports))
+			for _, port := range rule.Ports {
+				ports = append(ports, port.Port.IntValue())
+			}
+			peer := "ingress controller"
+			if rule.From[0].IPBlock != nil {
+				peer = rule.From[0].IPBlock.CIDR
+			}
+			rules[peer] = fmt.Sprint(ports)
+		}
+		return rules
+	}
+
+	source.set(newPublicPort("a", "http", 5000), newPublicPort("b", "tcp", 6000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"203.0.113.0/24": "[6000]", "ingress controller": "[5000]"}
+	if rules := getRules(); fmt.Sprint(rules) != fmt.Sprint(expected) {
+		t.Errorf("Expected ingress rules %v, got %v", expected, rules)
+	}
+
+	// Without other ports, the CIDR rule would allow every port
+	source.set(newPublicPort("a", "http", 5000))
+	if err := mgr.run(); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]string{"ingress controller": "[5000]"}
+	if rules := getRules(); fmt.Sprint(rules) != fmt.Sprint(expected) {
+		t.Errorf("Expected ingress rules %v, got %v", expected, rules)
+	}
+}